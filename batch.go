@@ -0,0 +1,118 @@
+package irc
+
+import (
+	"sync"
+)
+
+// Batch is an IRCv3 BATCH, see https://ircv3.net/specs/extensions/batch
+type Batch struct {
+	Ref    string
+	Type   string
+	Params []string
+
+	// Start is the BATCH +ref msg, e.g. for its label tag.
+	Start *Msg
+
+	// Msgs of the batch in order, without BATCH msgs of nested batches.
+	Msgs []*Msg
+
+	// Nested batches in order of their end.
+	Nested []*Batch
+
+	parent *Batch
+	stream bool
+}
+
+// Batcher is a Handler which collects msgs tagged with batch and passes
+// each completed outermost batch to OnBatch, other msgs go to Handler.
+// The batch cap must be enabled.
+type Batcher struct {
+	Handler Handler
+	OnBatch func(w Writer, b *Batch)
+
+	// Stream selects batches, e.g. large chathistory, whose msgs go to
+	// Handler as they arrive including BATCH msgs, b has no msgs yet.
+	// Batches nested in a streamed batch are streamed too.
+	Stream func(b *Batch) bool
+
+	mu   sync.Mutex
+	open map[string]*Batch
+}
+
+// NewBatcher returns a batcher passing batches to onBatch and other msgs
+// to h.
+func NewBatcher(h Handler, onBatch func(w Writer, b *Batch)) *Batcher {
+	return &Batcher{Handler: h, OnBatch: onBatch}
+}
+
+// ServeIRC collects msg into its batch or passes it on.
+func (bt *Batcher) ServeIRC(w Writer, msg *Msg) {
+	done, pass := bt.collect(msg)
+	if pass && bt.Handler != nil {
+		bt.Handler.ServeIRC(w, msg)
+	}
+	if done != nil && bt.OnBatch != nil {
+		bt.OnBatch(w, done)
+	}
+}
+
+// collect returns a completed outermost batch and whether msg is passed to
+// Handler.
+func (bt *Batcher) collect(msg *Msg) (done *Batch, pass bool) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	var parent *Batch
+	if ref, ok := msg.Tag([]byte("batch")); ok {
+		parent = bt.open[string(ref)]
+	}
+
+	// BATCH +ref type params... or -ref, any of them may be the trailing
+	var ref string
+	if string(msg.Cmd()) == BATCH {
+		ref = msg.arg(0)
+	}
+	if len(ref) > 1 {
+		sign := ref[0]
+		ref = ref[1:]
+		switch sign {
+		case '+':
+			b := &Batch{Ref: ref, parent: parent}
+			if n := msg.argCount(); n > 1 {
+				b.Type = msg.arg(1)
+				for i := 2; i < n; i++ {
+					b.Params = append(b.Params, msg.arg(i))
+				}
+			}
+			b.stream = parent != nil && parent.stream || parent == nil && bt.Stream != nil && bt.Stream(b)
+			if !b.stream {
+				b.Start = msg.Clone()
+			}
+			if bt.open == nil {
+				bt.open = make(map[string]*Batch)
+			}
+			bt.open[ref] = b
+			return nil, b.stream
+		case '-':
+			b, ok := bt.open[ref]
+			if !ok {
+				return nil, true
+			}
+			delete(bt.open, ref)
+			if b.stream {
+				return nil, true
+			}
+			if b.parent != nil {
+				b.parent.Nested = append(b.parent.Nested, b)
+				return nil, false
+			}
+			return b, false
+		}
+	}
+
+	if parent == nil || parent.stream {
+		return nil, true
+	}
+	parent.Msgs = append(parent.Msgs, msg.Clone())
+	return nil, false
+}
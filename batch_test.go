@@ -0,0 +1,123 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	var passed []string
+	var batches []*Batch
+	bt := NewBatcher(HandlerFunc(func(w Writer, msg *Msg) {
+		passed = append(passed, string(msg.Cmd())+" "+string(msg.Trailing()))
+	}), func(w Writer, b *Batch) {
+		batches = append(batches, b)
+	})
+
+	for _, raw := range []string{
+		":srv BATCH +yXNAbvnRHTRBv netsplit irc.hub other.host",
+		"@batch=yXNAbvnRHTRBv :aji!a@a QUIT :irc.hub other.host",
+		":bob!b@host PRIVMSG #go :outside",
+		"@batch=yXNAbvnRHTRBv :nenolod!a@a QUIT :irc.hub other.host",
+		"@batch=unknown :carol!c@c PRIVMSG #go :unknown batch",
+		":srv BATCH -yXNAbvnRHTRBv",
+
+		// nested
+		"@label=7 :srv BATCH +outer labeled-response",
+		"@batch=outer :srv BATCH +inner chathistory #go",
+		"@batch=inner :bob!b@host PRIVMSG #go :old",
+		"@batch=outer :srv NOTICE me :between",
+		"@batch=inner :bob!b@host PRIVMSG #go :older",
+		"@batch=outer :srv BATCH -inner",
+		":srv BATCH -outer",
+	} {
+		bt.ServeIRC(nil, newTestMsg(raw))
+	}
+
+	if want := []string{"PRIVMSG outside", "PRIVMSG unknown batch"}; !reflect.DeepEqual(passed, want) {
+		t.Errorf("passed %q", passed)
+	}
+	if len(batches) != 2 {
+		t.Fatal(len(batches))
+	}
+
+	b := batches[0]
+	if b.Ref != "yXNAbvnRHTRBv" || b.Type != "netsplit" ||
+		!reflect.DeepEqual(b.Params, []string{"irc.hub", "other.host"}) || len(b.Msgs) != 2 ||
+		string(b.Msgs[1].Name()) != "nenolod" {
+		t.Errorf("netsplit %+v", b)
+	}
+
+	b = batches[1]
+	if label, _ := b.Start.Tag([]byte("label")); string(label) != "7" {
+		t.Error("label", string(label))
+	}
+	if len(b.Msgs) != 1 || string(b.Msgs[0].Trailing()) != "between" || len(b.Nested) != 1 {
+		t.Fatalf("outer %+v", b)
+	}
+	inner := b.Nested[0]
+	if inner.Type != "chathistory" || len(inner.Msgs) != 2 || string(inner.Msgs[1].Trailing()) != "older" {
+		t.Errorf("inner %+v", inner)
+	}
+}
+
+func TestBatcherStream(t *testing.T) {
+	var passed []string
+	bt := NewBatcher(HandlerFunc(func(w Writer, msg *Msg) {
+		passed = append(passed, string(msg.Data))
+	}), func(w Writer, b *Batch) {
+		t.Error("collected", b.Ref)
+	})
+	bt.Stream = func(b *Batch) bool { return b.Type == "chathistory" }
+
+	lines := []string{
+		":srv BATCH +h chathistory #go",
+		"@batch=h :srv BATCH +n netsplit a b",
+		"@batch=n :x!x@x QUIT :a b",
+		"@batch=h :srv BATCH -n",
+		"@batch=h :bob!b@host PRIVMSG #go :old",
+		":srv BATCH -h",
+	}
+	for _, raw := range lines {
+		bt.ServeIRC(nil, newTestMsg(raw))
+	}
+	if !reflect.DeepEqual(passed, lines) {
+		t.Errorf("passed %q", passed)
+	}
+}
+
+func TestBatcherArgs(t *testing.T) {
+	var passed []string
+	var batches []*Batch
+	bt := NewBatcher(HandlerFunc(func(w Writer, msg *Msg) {
+		passed = append(passed, string(msg.Data))
+	}), func(w Writer, b *Batch) {
+		batches = append(batches, b)
+	})
+
+	for _, raw := range []string{
+		":srv BATCH +r1 :chathistory",
+		"@batch=r1 :bob!b@host PRIVMSG #go :old",
+		":srv BATCH :-r1",
+		":srv BATCH +r2 netsplit a :b c",
+		":srv BATCH -r2",
+		":srv BATCH",
+		":srv BATCH +",
+		":srv BATCH :",
+	} {
+		bt.ServeIRC(nil, newTestMsg(raw))
+	}
+
+	if want := []string{":srv BATCH", ":srv BATCH +", ":srv BATCH :"}; !reflect.DeepEqual(passed, want) {
+		t.Errorf("passed %q", passed)
+	}
+	if len(batches) != 2 {
+		t.Fatal(len(batches))
+	}
+	if b := batches[0]; b.Ref != "r1" || b.Type != "chathistory" || b.Params != nil || len(b.Msgs) != 1 {
+		t.Errorf("trailing type %+v", b)
+	}
+	if b := batches[1]; b.Type != "netsplit" || !reflect.DeepEqual(b.Params, []string{"a", "b c"}) {
+		t.Errorf("trailing param %+v", b)
+	}
+}
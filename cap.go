@@ -0,0 +1,309 @@
+package irc
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CapVersion is sent with CAP LS.
+const CapVersion = "302"
+
+// maxCapReq is the max length of caps list in one CAP REQ line.
+const maxCapReq = 400
+
+// Caps negotiates IRCv3 capabilities, see
+// http://ircv3.net/specs/core/capability-negotiation-3.2.html
+//
+// Start sends CAP LS 302, then msgs with command CAP should be passed to
+// ServeIRC. Wanted caps that the server offers are requested, CAP END is
+// sent once every request is answered and no one holds the negotiation.
+type Caps struct {
+	// OnAck is called for each cap enabled by the server, it may call Hold
+	// to delay CAP END, e.g. for SASL.
+	OnAck func(w Writer, name string)
+
+	mu        sync.Mutex
+	wanted    map[string]bool
+	available map[string]string
+	enabled   map[string]bool
+	pending   map[string]bool
+	ls        map[string]string // LS reply in progress
+	list      map[string]bool   // LIST reply in progress
+	started   bool
+	lsDone    bool
+	ended     bool
+	hold      int
+}
+
+// NewCaps returns a negotiator that requests wanted caps.
+func NewCaps(wanted ...string) *Caps {
+	c := &Caps{
+		wanted:    make(map[string]bool),
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+		pending:   make(map[string]bool),
+	}
+	for _, name := range wanted {
+		c.wanted[name] = true
+	}
+	return c
+}
+
+// Start sends CAP LS 302.
+func (c *Caps) Start(w Writer) (err error) {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+	return writeCap(w, CAP_LS, CapVersion)
+}
+
+// Available returns the value of name offered by server and whether the
+// server offers it.
+func (c *Caps) Available(name string) (value string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok = c.available[name]
+	return
+}
+
+// Enabled reports whether name is acknowledged by server.
+func (c *Caps) Enabled(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled[name]
+}
+
+// List returns the enabled caps in order.
+func (c *Caps) List() (caps []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.enabled {
+		caps = append(caps, name)
+	}
+	sort.Strings(caps)
+	return
+}
+
+// Done reports whether CAP END has been sent.
+func (c *Caps) Done() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ended
+}
+
+// Hold delays CAP END until Release is called.
+func (c *Caps) Hold() {
+	c.mu.Lock()
+	c.hold++
+	c.mu.Unlock()
+}
+
+// Release undoes a Hold and sends CAP END if negotiation is finished. It
+// panics if called more times than Hold.
+func (c *Caps) Release(w Writer) error {
+	c.mu.Lock()
+	if c.hold == 0 {
+		c.mu.Unlock()
+		panic("irc: Caps.Release without Hold")
+	}
+	c.hold--
+	c.mu.Unlock()
+	return c.end(w)
+}
+
+// Request sends CAP REQ for caps, e.g. "-echo-message" disables it.
+func (c *Caps) Request(w Writer, caps ...string) (err error) {
+	c.mu.Lock()
+	for _, name := range caps {
+		c.pending[strings.TrimPrefix(name, "-")] = true
+	}
+	c.mu.Unlock()
+
+	var line string
+	for _, name := range caps {
+		if line != "" && len(line)+len(name)+1 > maxCapReq {
+			if err = writeCap(w, CAP_REQ, line); err != nil {
+				return
+			}
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += name
+	}
+	if line != "" {
+		err = writeCap(w, CAP_REQ, line)
+	}
+	return
+}
+
+// ServeIRC handles a CAP msg.
+func (c *Caps) ServeIRC(w Writer, msg *Msg) {
+	_ = c.handle(w, msg)
+}
+
+func (c *Caps) handle(w Writer, msg *Msg) (err error) {
+	if string(msg.Cmd()) != CAP {
+		return
+	}
+
+	params := msg.Params()
+	if len(params) < 2 {
+		return
+	}
+	sub := string(params[1])
+	more := len(params) > 2 && string(params[2]) == "*"
+
+	caps := msg.Trailing()
+	if caps == nil && len(params) > 2 && !more {
+		caps = params[len(params)-1]
+	}
+	fields := bytes.Fields(caps)
+
+	switch sub {
+	case CAP_LS:
+		c.mu.Lock()
+		if c.ls == nil {
+			c.ls = make(map[string]string)
+		}
+		for _, f := range fields {
+			name, value := splitCap(f)
+			c.ls[name] = value
+		}
+		if more {
+			c.mu.Unlock()
+			return
+		}
+		for name, value := range c.ls {
+			c.available[name] = value
+		}
+		c.ls = nil
+		c.lsDone = true
+		c.mu.Unlock()
+		return c.requestWanted(w)
+
+	case CAP_NEW:
+		c.mu.Lock()
+		for _, f := range fields {
+			name, value := splitCap(f)
+			c.available[name] = value
+		}
+		c.mu.Unlock()
+		return c.requestWanted(w)
+
+	case CAP_DEL:
+		c.mu.Lock()
+		for _, f := range fields {
+			name, _ := splitCap(f)
+			delete(c.available, name)
+			delete(c.enabled, name)
+			delete(c.pending, name)
+		}
+		c.mu.Unlock()
+
+	case CAP_LIST:
+		c.mu.Lock()
+		if c.list == nil {
+			c.list = make(map[string]bool)
+		}
+		for _, f := range fields {
+			name, _ := splitCap(f)
+			c.list[name] = true
+		}
+		if !more {
+			c.enabled, c.list = c.list, nil
+		}
+		c.mu.Unlock()
+
+	case CAP_ACK:
+		var acked []string
+		c.mu.Lock()
+		for _, f := range fields {
+			name, _ := splitCap(f)
+			disable := false
+			for len(name) > 0 && (name[0] == '-' || name[0] == '~' || name[0] == '=') {
+				disable = disable || name[0] == '-'
+				name = name[1:]
+			}
+			delete(c.pending, name)
+			if disable {
+				delete(c.enabled, name)
+				continue
+			}
+			c.enabled[name] = true
+			acked = append(acked, name)
+		}
+		onAck := c.OnAck
+		c.mu.Unlock()
+		if onAck != nil {
+			for _, name := range acked {
+				onAck(w, name)
+			}
+		}
+		return c.end(w)
+
+	case CAP_NAK:
+		c.mu.Lock()
+		for _, f := range fields {
+			name, _ := splitCap(f)
+			delete(c.pending, strings.TrimPrefix(name, "-"))
+		}
+		c.mu.Unlock()
+		return c.end(w)
+	}
+	return
+}
+
+// requestWanted requests wanted caps that are offered and not enabled yet.
+func (c *Caps) requestWanted(w Writer) (err error) {
+	var req []string
+	c.mu.Lock()
+	for name := range c.wanted {
+		if _, ok := c.available[name]; ok && !c.enabled[name] && !c.pending[name] {
+			req = append(req, name)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(req) == 0 {
+		return c.end(w)
+	}
+	sort.Strings(req)
+	return c.Request(w, req...)
+}
+
+// end sends CAP END once if registration negotiation is finished.
+func (c *Caps) end(w Writer) error {
+	c.mu.Lock()
+	if !c.started || !c.lsDone || c.ended || c.hold > 0 || len(c.pending) > 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	c.ended = true
+	c.mu.Unlock()
+	return writeCap(w, CAP_END, "")
+}
+
+func splitCap(f []byte) (name, value string) {
+	if n := bytes.IndexByte(f, '='); n >= 0 {
+		return string(f[:n]), string(f[n+1:])
+	}
+	return string(f), ""
+}
+
+func writeCap(w Writer, sub, arg string) (err error) {
+	msg := new(Msg)
+	msg.SetCmd([]byte(CAP))
+	msg.SetParams([]byte(sub))
+	switch {
+	case sub == CAP_REQ:
+		msg.SetTrailing([]byte(arg))
+	case arg != "":
+		msg.AppendParams([]byte(arg))
+	}
+	_, err = w.Encode(msg)
+	return
+}
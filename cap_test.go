@@ -0,0 +1,160 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func feedCaps(t *testing.T, c *Caps, w Writer, lines ...string) {
+	for _, l := range lines {
+		msg, err := NewMsg(s2b(l))
+		if err != nil {
+			t.Fatal(l, err)
+		}
+		if err = c.handle(w, msg); err != nil {
+			t.Fatal(l, err)
+		}
+	}
+}
+
+func TestCapsNegotiate(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+	c := NewCaps("sasl", "message-tags", "echo-message", "unknown")
+
+	c.Start(w)
+	feedCaps(t, c, w,
+		":srv CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL",
+		":srv CAP * LS :message-tags echo-message")
+	if c.Done() {
+		t.Error("done before ACK")
+	}
+	if v, ok := c.Available("sasl"); !ok || v != "PLAIN,EXTERNAL" {
+		t.Error(v, ok)
+	}
+
+	feedCaps(t, c, w, ":srv CAP * ACK :echo-message message-tags")
+	if c.Done() {
+		t.Error("done before sasl")
+	}
+	feedCaps(t, c, w, ":srv CAP * NAK :sasl")
+
+	want := "CAP LS 302\r\n" +
+		"CAP REQ :echo-message message-tags sasl\r\n" +
+		"CAP END\r\n"
+	if buf.String() != want {
+		t.Errorf("%q", buf.String())
+	}
+	if !c.Done() || !c.Enabled("message-tags") || c.Enabled("sasl") {
+		t.Error(c.List())
+	}
+}
+
+func TestCapsNoneWanted(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+	c := NewCaps("sasl")
+	c.Start(w)
+	feedCaps(t, c, w, ":srv CAP * LS :multi-prefix")
+	if buf.String() != "CAP LS 302\r\nCAP END\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+}
+
+func TestCapsHold(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+	c := NewCaps("sasl")
+	c.OnAck = func(w Writer, name string) {
+		if name == "sasl" {
+			c.Hold()
+		}
+	}
+	c.Start(w)
+	feedCaps(t, c, w, ":srv CAP * LS :sasl", ":srv CAP * ACK sasl")
+	if c.Done() {
+		t.Error("done while held")
+	}
+	c.Release(w)
+	if !c.Done() || buf.String() != "CAP LS 302\r\nCAP REQ :sasl\r\nCAP END\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unbalanced Release did not panic")
+		}
+	}()
+	c.Release(w)
+}
+
+func TestCapsRuntime(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+	c := NewCaps("away-notify")
+	c.Start(w)
+	feedCaps(t, c, w, ":srv CAP * LS :multi-prefix")
+	buf.Reset()
+
+	feedCaps(t, c, w, ":srv CAP nick NEW :away-notify")
+	feedCaps(t, c, w, ":srv CAP nick ACK :away-notify")
+	if buf.String() != "CAP REQ :away-notify\r\n" || !c.Enabled("away-notify") {
+		t.Errorf("%q", buf.String())
+	}
+
+	feedCaps(t, c, w, ":srv CAP nick DEL :away-notify")
+	if c.Enabled("away-notify") {
+		t.Error("enabled after DEL")
+	}
+	if _, ok := c.Available("away-notify"); ok {
+		t.Error("available after DEL")
+	}
+
+	feedCaps(t, c, w,
+		":srv CAP nick LIST * :a b",
+		":srv CAP nick LIST :c")
+	if l := c.List(); len(l) != 3 || l[0] != "a" || l[2] != "c" {
+		t.Error(l)
+	}
+}
+
+func TestCapsRequestSplit(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+	c := NewCaps()
+	var caps []string
+	for i := 0; i < 50; i++ {
+		caps = append(caps, "vendor.example.org/capability")
+	}
+	c.Request(w, caps...)
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\r\n"))
+	if len(lines) < 2 {
+		t.Fatal(len(lines))
+	}
+	for _, l := range lines {
+		if len(l) > 512 {
+			t.Error(len(l))
+		}
+	}
+}
+
+func TestClientCaps(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot", Caps: []string{"message-tags"}})
+	go func() {
+		srv.expect("CAP LS 302")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv CAP * LS :message-tags")
+		srv.expect("CAP REQ :message-tags")
+		srv.send(":srv CAP * ACK :message-tags")
+		srv.expect("CAP END")
+		srv.send(":srv 001 bot :Welcome")
+	}()
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Caps().Enabled("message-tags") {
+		t.Error(c.Caps().List())
+	}
+}
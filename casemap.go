@@ -0,0 +1,241 @@
+package irc
+
+// CaseMapping folds nicks and channel names, see CASEMAPPING of
+// http://modern.ircdocs.horse/#casemapping-parameter
+type CaseMapping int
+
+const (
+	RFC1459       CaseMapping = iota // A-Z and []\~ fold to a-z and {}|^
+	StrictRFC1459                    // A-Z and []\ fold to a-z and {}|
+	ASCII                            // A-Z fold to a-z
+)
+
+// CaseMappingOf returns the mapping of CASEMAPPING token value,
+// RFC1459 for unknown names.
+func CaseMappingOf(name string) CaseMapping {
+	switch name {
+	case "ascii":
+		return ASCII
+	case "strict-rfc1459":
+		return StrictRFC1459
+	}
+	return RFC1459
+}
+
+func (c CaseMapping) String() string {
+	switch c {
+	case ASCII:
+		return "ascii"
+	case StrictRFC1459:
+		return "strict-rfc1459"
+	}
+	return "rfc1459"
+}
+
+// CaseMap returns the CaseMapping of CASEMAPPING.
+func (s *ISupport) CaseMap() CaseMapping {
+	return CaseMappingOf(s.CaseMapping())
+}
+
+// FoldByte returns the lower case of b.
+func (c CaseMapping) FoldByte(b byte) byte {
+	switch {
+	case 'A' <= b && b <= 'Z':
+		return b + 'a' - 'A'
+	case c == ASCII:
+	case b == '[', b == ']', b == '\\':
+		return b + '{' - '['
+	case b == '~' && c == RFC1459:
+		return '^'
+	}
+	return b
+}
+
+// Fold appends the lower case of b to dst and returns the extended buffer.
+func (c CaseMapping) Fold(dst, b []byte) []byte {
+	for _, x := range b {
+		dst = append(dst, c.FoldByte(x))
+	}
+	return dst
+}
+
+// FoldString returns the lower case of s.
+func (c CaseMapping) FoldString(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c.FoldByte(s[i]) != s[i] {
+			b := make([]byte, len(s))
+			for j := range b {
+				b[j] = c.FoldByte(s[j])
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+// Equal reports whether a and b are equal under case folding.
+func (c CaseMapping) Equal(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if c.FoldByte(a[i]) != c.FoldByte(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualString reports whether a and b are equal under case folding.
+func (c CaseMapping) EqualString(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if c.FoldByte(a[i]) != c.FoldByte(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// FoldMap is a map keyed by nick or channel name under a CaseMapping,
+// it keeps the key as first set. It is not safe for concurrent writes.
+type FoldMap[V any] struct {
+	cm CaseMapping
+	m  map[string]foldEntry[V]
+}
+
+type foldEntry[V any] struct {
+	key   string
+	value V
+}
+
+func NewFoldMap[V any](cm CaseMapping) *FoldMap[V] {
+	return &FoldMap[V]{cm: cm, m: make(map[string]foldEntry[V])}
+}
+
+// lookup finds key, without allocation for short keys.
+func (m *FoldMap[V]) lookup(key string) (e foldEntry[V], ok bool) {
+	var buf [64]byte
+	if len(key) <= len(buf) {
+		for i := 0; i < len(key); i++ {
+			buf[i] = m.cm.FoldByte(key[i])
+		}
+		e, ok = m.m[string(buf[:len(key)])]
+		return
+	}
+	e, ok = m.m[m.cm.FoldString(key)]
+	return
+}
+
+// CaseMapping returns the mapping of keys.
+func (m *FoldMap[V]) CaseMapping() CaseMapping {
+	return m.cm
+}
+
+// SetCaseMapping re-keys the map by cm, entries that become equal are
+// merged.
+func (m *FoldMap[V]) SetCaseMapping(cm CaseMapping) {
+	if cm == m.cm {
+		return
+	}
+	old := m.m
+	m.cm, m.m = cm, make(map[string]foldEntry[V], len(old))
+	for _, e := range old {
+		m.m[cm.FoldString(e.key)] = e
+	}
+}
+
+// Get returns value of key.
+func (m *FoldMap[V]) Get(key string) (value V, ok bool) {
+	e, ok := m.lookup(key)
+	return e.value, ok
+}
+
+// Key returns key as it was first set.
+func (m *FoldMap[V]) Key(key string) (string, bool) {
+	e, ok := m.lookup(key)
+	return e.key, ok
+}
+
+// Set value of key, the case of an existing key is kept.
+func (m *FoldMap[V]) Set(key string, value V) {
+	folded := m.cm.FoldString(key)
+	if e, ok := m.m[folded]; ok {
+		key = e.key
+	}
+	m.m[folded] = foldEntry[V]{key, value}
+}
+
+// Rename moves value of old to key, it reports whether old exists.
+func (m *FoldMap[V]) Rename(old, key string) bool {
+	e, ok := m.lookup(old)
+	if !ok {
+		return false
+	}
+	m.Delete(old)
+	m.m[m.cm.FoldString(key)] = foldEntry[V]{key, e.value}
+	return true
+}
+
+// Delete key.
+func (m *FoldMap[V]) Delete(key string) {
+	delete(m.m, m.cm.FoldString(key))
+}
+
+// Len returns number of keys.
+func (m *FoldMap[V]) Len() int {
+	return len(m.m)
+}
+
+// Range calls f for each key and value until f returns false.
+func (m *FoldMap[V]) Range(f func(key string, value V) bool) {
+	for _, e := range m.m {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// FoldSet is a set of nicks or channel names under a CaseMapping.
+type FoldSet struct {
+	m *FoldMap[struct{}]
+}
+
+func NewFoldSet(cm CaseMapping) *FoldSet {
+	return &FoldSet{NewFoldMap[struct{}](cm)}
+}
+
+// Add key to set.
+func (s *FoldSet) Add(key string) {
+	s.m.Set(key, struct{}{})
+}
+
+// Has reports whether key is in set.
+func (s *FoldSet) Has(key string) bool {
+	_, ok := s.m.lookup(key)
+	return ok
+}
+
+// Delete key from set.
+func (s *FoldSet) Delete(key string) {
+	s.m.Delete(key)
+}
+
+// Len returns number of keys.
+func (s *FoldSet) Len() int {
+	return s.m.Len()
+}
+
+// Range calls f for each key until f returns false.
+func (s *FoldSet) Range(f func(key string) bool) {
+	s.m.Range(func(key string, _ struct{}) bool {
+		return f(key)
+	})
+}
+
+// SetCaseMapping re-keys the set by cm.
+func (s *FoldSet) SetCaseMapping(cm CaseMapping) {
+	s.m.SetCaseMapping(cm)
+}
@@ -0,0 +1,125 @@
+package irc
+
+import "testing"
+
+func TestCaseMappingEqual(t *testing.T) {
+	for _, c := range []struct {
+		cm    CaseMapping
+		a, b  string
+		equal bool
+	}{
+		{RFC1459, "#Foo[1]", "#foo{1}", true},
+		{RFC1459, "Nick\\~", "nick|^", true},
+		{StrictRFC1459, "Nick\\", "nick|", true},
+		{StrictRFC1459, "nick~", "nick^", false},
+		{ASCII, "NICK", "nick", true},
+		{ASCII, "#foo[1]", "#foo{1}", false},
+		{RFC1459, "foo", "fo", false},
+	} {
+		if got := c.cm.EqualString(c.a, c.b); got != c.equal {
+			t.Errorf("%s %q %q got %v", c.cm, c.a, c.b, got)
+		}
+		if got := c.cm.Equal([]byte(c.a), []byte(c.b)); got != c.equal {
+			t.Errorf("%s %q %q got %v", c.cm, c.a, c.b, got)
+		}
+	}
+}
+
+func TestCaseMappingFold(t *testing.T) {
+	if s := RFC1459.FoldString("ABC[]\\~"); s != "abc{}|^" {
+		t.Error(s)
+	}
+	if s := StrictRFC1459.FoldString("ABC[]\\~"); s != "abc{}|~" {
+		t.Error(s)
+	}
+	if s := string(ASCII.Fold(nil, []byte("ABC[]\\~"))); s != "abc[]\\~" {
+		t.Error(s)
+	}
+	for _, name := range []string{"rfc1459", "strict-rfc1459", "ascii"} {
+		if s := CaseMappingOf(name).String(); s != name {
+			t.Error(s, name)
+		}
+	}
+	is := newISupport(t, ":srv 005 me CASEMAPPING=strict-rfc1459 :are supported")
+	if is.CaseMap() != StrictRFC1459 {
+		t.Error(is.CaseMap())
+	}
+}
+
+func TestCaseMappingAllocs(t *testing.T) {
+	a, b := []byte("#Foo[1]"), []byte("#foo{1}")
+	m := NewFoldMap[int](RFC1459)
+	m.Set("#Foo[1]", 1)
+	n := testing.AllocsPerRun(100, func() {
+		if !RFC1459.Equal(a, b) || !RFC1459.EqualString("Nick", "nick") {
+			t.Fatal("not equal")
+		}
+		if v, ok := m.Get("#FOO{1}"); !ok || v != 1 {
+			t.Fatal("not found")
+		}
+	})
+	if n != 0 {
+		t.Errorf("%v allocs", n)
+	}
+}
+
+func TestFoldMap(t *testing.T) {
+	m := NewFoldMap[int](RFC1459)
+	m.Set("Alice[m]", 1)
+	m.Set("ALICE{M}", 2)
+	if m.Len() != 1 {
+		t.Fatal(m.Len())
+	}
+	if v, ok := m.Get("alice[m]"); !ok || v != 2 {
+		t.Error(v, ok)
+	}
+	if k, _ := m.Key("alice{m}"); k != "Alice[m]" {
+		t.Error(k)
+	}
+	if !m.Rename("alice{m}", "Bob") || m.Rename("alice", "x") {
+		t.Error("rename")
+	}
+	if k, ok := m.Key("BOB"); !ok || k != "Bob" {
+		t.Error(k, ok)
+	}
+	if _, ok := m.Get("alice[m]"); ok {
+		t.Error("old key kept")
+	}
+
+	m.Set("x[", 3)
+	m.SetCaseMapping(ASCII)
+	if _, ok := m.Get("x{"); ok {
+		t.Error("ascii folded [")
+	}
+	if v, ok := m.Get("X["); !ok || v != 3 {
+		t.Error(v, ok)
+	}
+	m.Delete("bob")
+	keys := 0
+	m.Range(func(key string, v int) bool {
+		keys++
+		return true
+	})
+	if keys != 1 || m.Len() != 1 {
+		t.Error(keys, m.Len())
+	}
+}
+
+func TestFoldSet(t *testing.T) {
+	s := NewFoldSet(StrictRFC1459)
+	s.Add("#Go~")
+	s.Add("#GO~")
+	if s.Len() != 1 || !s.Has("#go~") || s.Has("#go^") {
+		t.Error(s.Len())
+	}
+	s.Range(func(key string) bool {
+		if key != "#Go~" {
+			t.Error(key)
+		}
+		return true
+	})
+	s.Delete("#gO~")
+	if s.Len() != 0 {
+		t.Error(s.Len())
+	}
+}
@@ -0,0 +1,350 @@
+package irc
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Writer writes msg to a connection, both *Encoder and *Client are Writers.
+type Writer interface {
+	Encode(msg *Msg) (n int, err error)
+}
+
+// Handler responds to a decoded msg.
+// msg is only valid until ServeIRC returns.
+type Handler interface {
+	ServeIRC(w Writer, msg *Msg)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as Handler.
+type HandlerFunc func(w Writer, msg *Msg)
+
+func (f HandlerFunc) ServeIRC(w Writer, msg *Msg) {
+	f(w, msg)
+}
+
+// Config of client registration.
+type Config struct {
+	Nick     string
+	User     string
+	RealName string
+	Password string
+
+	// Caps are requested by CAP negotiation if the server offers them.
+	Caps []string
+
+	// SASL authenticates during registration if set, Register fails if
+	// authentication is not successful.
+	SASL SASLMechanism
+
+	// AltNick returns the next nick to try when the server rejects nick,
+	// default appends '_'. Alt nicks are cut to NICKLEN.
+	AltNick func(nick string) string
+}
+
+// maxAltNicks is the max number of alt nicks tried by Register.
+const maxAltNicks = 5
+
+func (cfg *Config) altNick(nick string) string {
+	if cfg.AltNick != nil {
+		return cfg.AltNick(nick)
+	}
+	return nick + "_"
+}
+
+// Client is a connection to an IRC server.
+type Client struct {
+	// Handler is called for every msg except PING, may be nil.
+	Handler Handler
+
+	// Keepalive is passed every msg if set, Register and Run return its
+	// *TimeoutError when it closed the connection.
+	Keepalive *Keepalive
+
+	conn net.Conn
+	dec  *Decoder
+	enc  *Encoder
+	cfg  Config
+	caps *Caps
+	sasl *SASL
+
+	isupport *ISupport
+
+	mu         sync.Mutex
+	nick       string
+	registered bool
+	queries    []*pendingQuery
+	queryErr   error // connection failed
+	label      int
+}
+
+// Dial connects to addr by TCP and returns an unregistered client, use
+// Dialer and NewClient for TLS.
+func Dial(addr string, cfg *Config) (c *Client, err error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	return NewClient(conn, cfg), nil
+}
+
+// NewClient returns a client that owns conn.
+func NewClient(conn net.Conn, cfg *Config) *Client {
+	c := &Client{
+		conn: conn,
+		dec:  NewDecoder(conn),
+		enc:  NewEncoder(conn),
+		cfg:  *cfg,
+		nick: cfg.Nick,
+
+		isupport: NewISupport(),
+	}
+	if c.cfg.User == "" {
+		c.cfg.User = c.cfg.Nick
+	}
+	if c.cfg.RealName == "" {
+		c.cfg.RealName = c.cfg.Nick
+	}
+	if c.cfg.SASL != nil {
+		c.cfg.Caps = append(c.cfg.Caps[:len(c.cfg.Caps):len(c.cfg.Caps)], "sasl")
+	}
+	if len(c.cfg.Caps) > 0 {
+		c.caps = NewCaps(c.cfg.Caps...)
+	}
+	if c.cfg.SASL != nil {
+		// a SASL numeric may come before the ACK, release only what is held
+		held := false
+		c.sasl = &SASL{
+			Mech: c.cfg.SASL,
+			Done: func(w Writer, err error) {
+				if held {
+					held = false
+					c.caps.Release(w)
+				}
+			},
+		}
+		c.caps.OnAck = func(w Writer, name string) {
+			if name == "sasl" && !held {
+				held = true
+				c.caps.Hold()
+				c.sasl.Start(w)
+			}
+		}
+	}
+	return c
+}
+
+// Nick returns current nick of client.
+func (c *Client) Nick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick
+}
+
+// Registered reports whether RPL_WELCOME has been received.
+func (c *Client) Registered() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registered
+}
+
+// Caps returns the capability negotiator, nil if Config.Caps is empty.
+func (c *Client) Caps() *Caps {
+	return c.caps
+}
+
+// ISupport returns RPL_ISUPPORT tokens received by client.
+func (c *Client) ISupport() *ISupport {
+	return c.isupport
+}
+
+// Conn returns the underlying connection.
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// Encode msg into connection.
+func (c *Client) Encode(msg *Msg) (n int, err error) {
+	return c.enc.Encode(msg)
+}
+
+// Send encodes cmd with params, the last param is sent as trailing if it is
+// empty, contains space or starts with ':'.
+func (c *Client) Send(cmd string, params ...string) (err error) {
+	msg, err := NewCmd(cmd, params...)
+	if err != nil {
+		return
+	}
+	_, err = c.enc.Encode(msg)
+	return
+}
+
+// Close the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Register sends CAP LS, PASS, NICK and USER then reads until RPL_WELCOME.
+// Nicks in use are retried with Config.AltNick up to 5 times, an erroneous
+// nick fails.
+func (c *Client) Register() error {
+	return c.keepaliveErr(c.register())
+}
+
+func (c *Client) register() (err error) {
+	if c.caps != nil {
+		if err = c.caps.Start(c); err != nil {
+			return
+		}
+	}
+	if c.cfg.Password != "" {
+		if err = c.Send(PASS, c.cfg.Password); err != nil {
+			return
+		}
+	}
+	if err = c.Send(NICK, c.Nick()); err != nil {
+		return
+	}
+	if err = c.Send(USER, c.cfg.User, "0", "*", c.cfg.RealName); err != nil {
+		return
+	}
+
+	msg := new(Msg)
+	attempts := 0
+	for {
+		if err = c.dec.Decode(msg); err != nil {
+			return
+		}
+		msg.ParseAll()
+
+		switch string(msg.Cmd()) {
+		case RPL_WELCOME:
+			if c.sasl != nil && (!c.sasl.Finished() || c.sasl.Err() != nil) {
+				return errors.New("irc: SASL authentication not completed")
+			}
+			c.mu.Lock()
+			if p := msg.Params(); len(p) > 0 {
+				c.nick = string(p[0])
+			}
+			c.registered = true
+			c.mu.Unlock()
+			return c.serve(msg)
+		case ERR_ERRONEUSNICKNAME:
+			return errors.New("irc: nick " + c.Nick() + " rejected: " + string(msg.Trailing()))
+		case ERR_NICKNAMEINUSE, ERR_NICKCOLLISION, ERR_UNAVAILRESOURCE:
+			c.mu.Lock()
+			old := c.nick
+			nick := c.altNick(old)
+			if attempts++; nick == old || attempts > maxAltNicks {
+				c.mu.Unlock()
+				return errors.New("irc: nick " + old + " rejected: " + string(msg.Trailing()))
+			}
+			c.nick = nick
+			c.mu.Unlock()
+			if err = c.Send(NICK, nick); err != nil {
+				return
+			}
+		case ERR_PASSWDMISMATCH, ERR_YOUREBANNEDCREEP:
+			return errors.New(string(msg.Cmd()) + " " + string(msg.Trailing()))
+		case ERROR:
+			return errors.New("ERROR " + string(msg.Trailing()))
+		}
+		if err = c.serve(msg); err != nil {
+			return
+		}
+		if c.sasl != nil && c.sasl.Finished() && c.sasl.Err() != nil {
+			return c.sasl.Err()
+		}
+	}
+}
+
+// altNick returns Config.AltNick of nick cut to NICKLEN, or the length of
+// Config.Nick if longer as the server accepted that.
+func (c *Client) altNick(nick string) string {
+	alt := c.cfg.altNick(nick)
+	limit := c.isupport.NickLen()
+	if n := len(c.cfg.Nick); n > limit {
+		limit = n
+	}
+	if len(alt) > limit {
+		alt = alt[:limit]
+	}
+	return alt
+}
+
+// Run reads msgs until connection fails, answers PING and passes others to
+// Handler. Pending queries fail with the error of connection.
+func (c *Client) Run() (err error) {
+	defer func() {
+		err = c.keepaliveErr(err)
+		c.failQueries(err)
+	}()
+	msg := new(Msg)
+	for {
+		if err = c.dec.Decode(msg); err != nil {
+			return
+		}
+		if err = c.serve(msg); err != nil {
+			return
+		}
+	}
+}
+
+// keepaliveErr returns the timeout of Keepalive instead of err caused by
+// it.
+func (c *Client) keepaliveErr(err error) error {
+	if err != nil && c.Keepalive != nil {
+		if kerr := c.Keepalive.Err(); kerr != nil {
+			return kerr
+		}
+	}
+	return err
+}
+
+func (c *Client) serve(msg *Msg) (err error) {
+	if c.Keepalive != nil {
+		c.Keepalive.ServeIRC(c, msg)
+	}
+
+	if string(msg.Cmd()) == PING {
+		pong := new(Msg)
+		pong.SetCmd([]byte(PONG))
+		pong.SetParams(msg.Params()...)
+		if t := msg.Trailing(); t != nil {
+			pong.SetTrailing(t)
+		}
+		_, err = c.enc.Encode(pong)
+		return
+	}
+
+	if string(msg.Cmd()) == CAP && c.caps != nil {
+		if err = c.caps.handle(c, msg); err != nil {
+			return
+		}
+	}
+
+	if c.sasl != nil && !c.Registered() {
+		if err = c.sasl.handle(c, msg); err != nil {
+			return
+		}
+	}
+
+	if string(msg.Cmd()) == NICK && c.isupport.CaseMap().EqualString(string(msg.Name()), c.Nick()) {
+		c.mu.Lock()
+		if p := msg.Params(); len(p) > 0 {
+			c.nick = string(p[0])
+		} else {
+			c.nick = string(msg.Trailing())
+		}
+		c.mu.Unlock()
+	}
+
+	c.isupport.Parse(msg)
+	c.routeQuery(msg)
+
+	if c.Handler != nil {
+		c.Handler.ServeIRC(c, msg)
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package irc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeServer reads lines from conn and answers by script.
+type fakeServer struct {
+	t    *testing.T
+	conn net.Conn
+	rdr  *bufio.Reader
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, net.Conn) {
+	s, c := net.Pipe()
+	return &fakeServer{t, s, bufio.NewReader(s)}, c
+}
+
+func (s *fakeServer) expect(line string) {
+	got, err := s.rdr.ReadString('\n')
+	if err != nil {
+		s.t.Error("expect", line, err)
+		return
+	}
+	if got != line+"\r\n" {
+		s.t.Errorf("expect %q got %q", line, got)
+	}
+}
+
+func (s *fakeServer) send(lines ...string) {
+	for _, l := range lines {
+		if _, err := io.WriteString(s.conn, l+"\r\n"); err != nil {
+			s.t.Error("send", l, err)
+		}
+	}
+}
+
+func TestClientRegister(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot", Password: "secret", RealName: "A Bot"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.expect("PASS secret")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * :A Bot")
+		srv.send(":srv NOTICE * :*** Looking up your hostname")
+		srv.send("PING :12345")
+		srv.expect("PONG :12345")
+		srv.send(":srv 433 * bot :Nickname is already in use")
+		srv.expect("NICK bot_")
+		srv.send(":srv 001 bot_ :Welcome")
+	}()
+
+	var notices int
+	c.Handler = HandlerFunc(func(w Writer, msg *Msg) {
+		if string(msg.Cmd()) == NOTICE {
+			notices++
+		}
+	})
+
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if !c.Registered() || c.Nick() != "bot_" || notices != 1 {
+		t.Error(c.Registered(), c.Nick(), notices)
+	}
+}
+
+func TestClientRegisterError(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot"})
+	go func() {
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send("ERROR :Closing link: banned")
+	}()
+	err := c.Register()
+	if err == nil || !strings.Contains(err.Error(), "banned") {
+		t.Error(err)
+	}
+}
+
+func TestClientRegisterNickRejected(t *testing.T) {
+	for _, c := range []struct {
+		nick  string
+		reply string
+		sent  []string
+	}{
+		{"bot", "433", []string{"bot", "bot_", "bot__", "bot___", "bot____", "bot_____"}},
+		{"bot", "432", []string{"bot"}},
+		// alt nicks are cut to NICKLEN 9
+		{"longname", "433", []string{"longname", "longname_"}},
+	} {
+		srv, conn := newFakeServer(t)
+		client := NewClient(conn, &Config{Nick: c.nick, User: "u"})
+		go func() {
+			for i, nick := range c.sent {
+				srv.expect("NICK " + nick)
+				if i == 0 {
+					srv.expect("USER u 0 * " + c.nick)
+				}
+				srv.send(":srv " + c.reply + " * " + nick + " :rejected")
+			}
+		}()
+		err := client.Register()
+		if err == nil || !strings.Contains(err.Error(), c.sent[len(c.sent)-1]) {
+			t.Error(c.nick, c.reply, err)
+		}
+	}
+}
+
+func TestClientRun(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot"})
+
+	var got []string
+	c.Handler = HandlerFunc(func(w Writer, msg *Msg) {
+		got = append(got, string(msg.Trailing()))
+		if string(msg.Trailing()) == "hello" {
+			w.(*Client).Send(PRIVMSG, "#chan", "hello back")
+		}
+	})
+
+	go func() {
+		srv.send("PING irc.example.net")
+		srv.expect("PONG irc.example.net")
+		srv.send(":bot!u@h NICK :robot")
+		srv.send(":Robot!u@h NICK :robot2") // nicks compare case-folded
+		srv.send(":nick!u@h PRIVMSG #chan :hello")
+		srv.expect("PRIVMSG #chan :hello back")
+		srv.conn.Close()
+	}()
+
+	if err := c.Run(); err != io.EOF {
+		t.Error(err)
+	}
+	if c.Nick() != "robot2" || len(got) != 3 {
+		t.Error(c.Nick(), got)
+	}
+}
@@ -0,0 +1,103 @@
+package irc
+
+import "sync"
+
+// Msgs from Decoder point into its buffer and are invalidated by the next
+// Decode. Clone, CopyTo or Detach a msg to keep it longer, pooled msgs reuse
+// their buffers so a detached msg costs no allocation in steady state.
+
+var msgPool = sync.Pool{
+	New: func() interface{} { return new(Msg) },
+}
+
+// AcquireMsg returns an empty msg from pool.
+func AcquireMsg() *Msg {
+	return msgPool.Get().(*Msg)
+}
+
+// ReleaseMsg resets msg and returns it to pool, msg must not be used after.
+func ReleaseMsg(m *Msg) {
+	m.Reset()
+	msgPool.Put(m)
+}
+
+// Clone returns a copy of msg which owns all its data.
+func (m *Msg) Clone() *Msg {
+	c := new(Msg)
+	m.CopyTo(c)
+	return c
+}
+
+// Detach copies Data and every field of msg into a buffer owned by msg.
+func (m *Msg) Detach() {
+	if !m.detached {
+		m.CopyTo(m)
+	}
+}
+
+// CopyTo copies msg into dst which owns all its data, buffers of dst are
+// reused.
+func (m *Msg) CopyTo(dst *Msg) {
+	own, tagBuf := dst.own[:0], dst.tagBuf[:0]
+	src := *m
+
+	fields := [...]*[]byte{&src.tags, &src.prefix, &src.name, &src.user,
+		&src.host, &src.cmd, &src.trailing}
+
+	// everything outside Data is appended after it
+	n := len(src.Data)
+	for _, f := range fields {
+		if _, ok := within(*f, src.Data); !ok {
+			n += len(*f)
+		}
+	}
+	for i := 0; i < src.paramsCount; i++ {
+		if _, ok := within(src.params[i], src.Data); !ok {
+			n += len(src.params[i])
+		}
+	}
+	if cap(own) < n {
+		own = make([]byte, 0, n)
+	}
+
+	own = append(own, src.Data...)
+	data := own[:len(src.Data):len(src.Data)]
+	rebase := func(p []byte) []byte {
+		if len(p) == 0 {
+			return p[:0:0]
+		}
+		if off, ok := within(p, src.Data); ok {
+			return data[off : off+len(p) : off+len(p)]
+		}
+		start := len(own)
+		own = append(own, p...)
+		return own[start:len(own):len(own)]
+	}
+
+	for _, f := range fields {
+		*f = rebase(*f)
+	}
+	for i := 0; i < src.paramsCount; i++ {
+		src.params[i] = rebase(src.params[i])
+	}
+	if src.Data != nil {
+		src.Data = data
+	}
+
+	src.own = own
+	src.tagBuf = tagBuf
+	src.detached = true
+	*dst = src
+}
+
+// within returns the offset of p in data if p is a subslice of data.
+func within(p, data []byte) (off int, ok bool) {
+	if len(p) == 0 || len(data) == 0 {
+		return
+	}
+	off = cap(data) - cap(p)
+	if off < 0 || off+len(p) > len(data) {
+		return 0, false
+	}
+	return off, &data[off] == &p[0]
+}
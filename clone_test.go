@@ -0,0 +1,104 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMsgClone(t *testing.T) {
+	for _, z := range messageTests {
+		data := s2b(z.rawMsg)
+		m, _ := NewMsg(data)
+		c := m.Clone()
+		for i := range data {
+			data[i] = 'X'
+		}
+		c.ParseAll()
+		p := z.parsed
+		if !bytes.Equal(c.Cmd(), p.cmd) || !bytes.Equal(c.Name(), p.name) ||
+			!bytes.Equal(c.Host(), p.host) || !bytes.Equal(c.Trailing(), p.trailing) {
+			t.Errorf("failed:%s\nparsed:%s", z.rawMsg, c.String())
+		}
+		for i, param := range c.Params() {
+			if !bytes.Equal(param, p.params[i]) {
+				t.Errorf("failed:%s\nparsed:%s", z.rawMsg, c.String())
+			}
+		}
+	}
+}
+
+func TestMsgDetach(t *testing.T) {
+	data := s2b("@a=1 :nick!u@h PRIVMSG #chan :hello")
+	m, _ := NewMsg(data)
+	m.ParseAll()
+	m.SetTag(s2b("b"), s2b("2"))
+	m.SetTrailing(s2b("changed"))
+	m.Detach()
+	copy(data, bytes.Repeat([]byte("X"), len(data)))
+
+	buf := bytes.NewBuffer(nil)
+	NewEncoder(buf).Encode(m)
+	if buf.String() != "@a=1;b=2 :nick!u@h PRIVMSG #chan :changed\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+	if string(m.Name()) != "nick" {
+		t.Error(m.Name())
+	}
+}
+
+func TestMsgDetachEmpty(t *testing.T) {
+	m, _ := NewMsg(s2b("PRIVMSG #chan :"))
+	m.ParseAll()
+	m.Detach()
+	if tr := m.Trailing(); tr == nil || len(tr) != 0 {
+		t.Errorf("%q", tr)
+	}
+	if m.Name() != nil {
+		t.Error(m.Name())
+	}
+}
+
+func TestMsgPool(t *testing.T) {
+	m := AcquireMsg()
+	m.Data = s2b("PING a")
+	m.PeekCmd()
+	ReleaseMsg(m)
+	m = AcquireMsg()
+	if m.Data != nil || m.Cmd() != nil {
+		t.Error(m)
+	}
+}
+
+func TestMsgCopyToAlloc(t *testing.T) {
+	src := s2b(":Namename!username@hostname COMMAND arg1 arg2 :Message message")
+	dec := NewDecoder(bytes.NewBuffer(bytes.Repeat(append(src, '\n'), 200)))
+
+	m, got := new(Msg), new(Msg)
+	dec.Decode(m)
+	m.CopyTo(got)
+	n := testing.AllocsPerRun(100, func() {
+		dec.Decode(m)
+		m.ParseAll()
+		got.Reset()
+		m.CopyTo(got)
+	})
+	if n != 0 {
+		t.Error("allocs", n)
+	}
+	if string(got.Trailing()) != "Message message" {
+		t.Error(got)
+	}
+}
+
+func BenchmarkMsgDetach(b *testing.B) {
+	src := s2b(":Namename!username@hostname COMMAND arg1 arg2 arg3 arg4 arg5 arg6 arg7 :Message message message message message")
+	m := new(Msg)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Reset()
+		m.Data = src
+		m.ParseAll()
+		m.Detach()
+	}
+}
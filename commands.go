@@ -0,0 +1,387 @@
+package irc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Constructors of outgoing msgs. Params are validated: no param holds CR,
+// LF or NUL, and params other than the last are non-empty without space and
+// do not start with ':'. Free text like the text of PRIVMSG is always sent
+// as trailing.
+
+// NewCmd returns a msg of cmd with params, the last param is sent as
+// trailing if it is empty, contains space or starts with ':'.
+func NewCmd(cmd string, params ...string) (*Msg, error) {
+	trailing := false
+	if n := len(params); n > 0 {
+		last := params[n-1]
+		trailing = last == "" || strings.IndexByte(last, space) >= 0 || last[0] == prefixSymbol
+	}
+	return newCmd(cmd, trailing, params...)
+}
+
+// newCmd returns a msg of cmd with params, the last param is sent as
+// trailing if trailing.
+func newCmd(cmd string, trailing bool, params ...string) (*Msg, error) {
+	if cmd == "" || strings.IndexAny(cmd, " \r\n\x00") >= 0 {
+		return nil, errors.New("irc: invalid command " + strconv.Quote(cmd))
+	}
+	if len(params) > len(Msg{}.params) {
+		return nil, errors.New("irc: too many params for " + cmd)
+	}
+
+	msg := new(Msg)
+	msg.SetCmd([]byte(cmd))
+	for i, p := range params {
+		if strings.IndexAny(p, "\r\n\x00") >= 0 {
+			return nil, errors.New("irc: param " + strconv.Quote(p) + " of " + cmd + " has CR, LF or NUL")
+		}
+		if trailing && i == len(params)-1 {
+			msg.SetTrailing([]byte(p))
+			break
+		}
+		if p == "" || strings.IndexByte(p, space) >= 0 || p[0] == prefixSymbol {
+			return nil, errors.New("irc: invalid param " + strconv.Quote(p) + " of " + cmd)
+		}
+		msg.AppendParams([]byte(p))
+	}
+	return msg, nil
+}
+
+// withText returns a msg of cmd with params and text as trailing.
+func withText(cmd string, text string, params ...string) (*Msg, error) {
+	return newCmd(cmd, true, append(params, text)...)
+}
+
+// optional appends p to params if it is not empty.
+func optional(params []string, p ...string) []string {
+	for _, s := range p {
+		if s != "" {
+			params = append(params, s)
+		}
+	}
+	return params
+}
+
+// Pass returns PASS password.
+func Pass(password string) (*Msg, error) {
+	return NewCmd(PASS, password)
+}
+
+// Nick returns NICK nick.
+func Nick(nick string) (*Msg, error) {
+	return newCmd(NICK, false, nick)
+}
+
+// User returns USER user 0 * :realName.
+func User(user, realName string) (*Msg, error) {
+	return withText(USER, realName, user, "0", "*")
+}
+
+// Oper returns OPER name password.
+func Oper(name, password string) (*Msg, error) {
+	return NewCmd(OPER, name, password)
+}
+
+// Mode returns MODE target with changes, it queries modes of target if
+// changes is empty. Use ModeMsgs to respect ISUPPORT MODES.
+func Mode(target string, changes []ModeChange) (*Msg, error) {
+	params := []string{target}
+	if len(changes) > 0 {
+		var modes []byte
+		var sign byte
+		var args []string
+		for _, c := range changes {
+			cs := byte('-')
+			if c.Add {
+				cs = '+'
+			}
+			if cs != sign {
+				modes = append(modes, cs)
+				sign = cs
+			}
+			modes = append(modes, c.Mode)
+			if c.Arg != "" {
+				args = append(args, c.Arg)
+			}
+		}
+		params = append(append(params, string(modes)), args...)
+	}
+	return newCmd(MODE, false, params...)
+}
+
+// Service returns SERVICE nick * distribution 0 0 :info.
+func Service(nick, distribution, info string) (*Msg, error) {
+	return withText(SERVICE, info, nick, "*", distribution, "0", "0")
+}
+
+// Quit returns QUIT with optional reason.
+func Quit(reason string) (*Msg, error) {
+	if reason == "" {
+		return newCmd(QUIT, false)
+	}
+	return withText(QUIT, reason)
+}
+
+// Squit returns SQUIT server :comment.
+func Squit(server, comment string) (*Msg, error) {
+	return withText(SQUIT, comment, server)
+}
+
+// Join returns JOIN of channels with keys, keys are matched to channels in
+// order.
+func Join(channels, keys []string) (*Msg, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("irc: JOIN needs a channel")
+	}
+	if len(keys) > len(channels) {
+		return nil, errors.New("irc: JOIN has more keys than channels")
+	}
+	params := []string{strings.Join(channels, ",")}
+	if len(keys) > 0 {
+		params = append(params, strings.Join(keys, ","))
+	}
+	return newCmd(JOIN, false, params...)
+}
+
+// Part returns PART of channels with optional reason.
+func Part(channels []string, reason string) (*Msg, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("irc: PART needs a channel")
+	}
+	if reason == "" {
+		return newCmd(PART, false, strings.Join(channels, ","))
+	}
+	return withText(PART, reason, strings.Join(channels, ","))
+}
+
+// Topic returns TOPIC which sets topic of channel, an empty topic clears it.
+func Topic(channel, topic string) (*Msg, error) {
+	return withText(TOPIC, topic, channel)
+}
+
+// TopicQuery returns TOPIC which queries topic of channel.
+func TopicQuery(channel string) (*Msg, error) {
+	return newCmd(TOPIC, false, channel)
+}
+
+// Names returns NAMES of channels.
+func Names(channels ...string) (*Msg, error) {
+	return newCmd(NAMES, false, optional(nil, strings.Join(channels, ","))...)
+}
+
+// List returns LIST of channels, all channels if empty.
+func List(channels ...string) (*Msg, error) {
+	return newCmd(LIST, false, optional(nil, strings.Join(channels, ","))...)
+}
+
+// Invite returns INVITE nick channel.
+func Invite(nick, channel string) (*Msg, error) {
+	return newCmd(INVITE, false, nick, channel)
+}
+
+// Kick returns KICK channel nick with optional reason.
+func Kick(channel, nick, reason string) (*Msg, error) {
+	if reason == "" {
+		return newCmd(KICK, false, channel, nick)
+	}
+	return withText(KICK, reason, channel, nick)
+}
+
+// Privmsg returns PRIVMSG target :text.
+func Privmsg(target, text string) (*Msg, error) {
+	return withText(PRIVMSG, text, target)
+}
+
+// Notice returns NOTICE target :text.
+func Notice(target, text string) (*Msg, error) {
+	return withText(NOTICE, text, target)
+}
+
+// Motd returns MOTD of optional server target.
+func Motd(target string) (*Msg, error) {
+	return newCmd(MOTD, false, optional(nil, target)...)
+}
+
+// Lusers returns LUSERS.
+func Lusers() (*Msg, error) {
+	return newCmd(LUSERS, false)
+}
+
+// Version returns VERSION of optional server target.
+func Version(target string) (*Msg, error) {
+	return newCmd(VERSION, false, optional(nil, target)...)
+}
+
+// Stats returns STATS query of optional server target.
+func Stats(query, target string) (*Msg, error) {
+	return newCmd(STATS, false, optional(nil, query, target)...)
+}
+
+// Links returns LINKS of optional server mask.
+func Links(mask string) (*Msg, error) {
+	return newCmd(LINKS, false, optional(nil, mask)...)
+}
+
+// Time returns TIME of optional server target.
+func Time(target string) (*Msg, error) {
+	return newCmd(TIME, false, optional(nil, target)...)
+}
+
+// Connect returns CONNECT target port with optional remote server.
+func Connect(target string, port int, remote string) (*Msg, error) {
+	return newCmd(CONNECT, false, optional([]string{target, strconv.Itoa(port)}, remote)...)
+}
+
+// Trace returns TRACE of optional target.
+func Trace(target string) (*Msg, error) {
+	return newCmd(TRACE, false, optional(nil, target)...)
+}
+
+// AdminInfo returns ADMIN of optional server target, Admin is the channel
+// admin prefix.
+func AdminInfo(target string) (*Msg, error) {
+	return newCmd(ADMIN, false, optional(nil, target)...)
+}
+
+// Info returns INFO of optional server target.
+func Info(target string) (*Msg, error) {
+	return newCmd(INFO, false, optional(nil, target)...)
+}
+
+// Servlist returns SERVLIST of optional mask and type.
+func Servlist(mask, typ string) (*Msg, error) {
+	return newCmd(SERVLIST, false, optional(nil, mask, typ)...)
+}
+
+// Squery returns SQUERY service :text.
+func Squery(service, text string) (*Msg, error) {
+	return withText(SQUERY, text, service)
+}
+
+// Who returns WHO mask.
+func Who(mask string) (*Msg, error) {
+	return newCmd(WHO, false, optional(nil, mask)...)
+}
+
+// Whois returns WHOIS nick.
+func Whois(nick string) (*Msg, error) {
+	return newCmd(WHOIS, false, nick)
+}
+
+// Whowas returns WHOWAS nick with count, count <= 0 is omitted.
+func Whowas(nick string, count int) (*Msg, error) {
+	params := []string{nick}
+	if count > 0 {
+		params = append(params, strconv.Itoa(count))
+	}
+	return newCmd(WHOWAS, false, params...)
+}
+
+// Kill returns KILL nick :comment.
+func Kill(nick, comment string) (*Msg, error) {
+	return withText(KILL, comment, nick)
+}
+
+// Ping returns PING :token.
+func Ping(token string) (*Msg, error) {
+	return withText(PING, token)
+}
+
+// Pong returns PONG :token.
+func Pong(token string) (*Msg, error) {
+	return withText(PONG, token)
+}
+
+// Error returns ERROR :message.
+func Error(message string) (*Msg, error) {
+	return withText(ERROR, message)
+}
+
+// Away returns AWAY :text, an empty text marks back.
+func Away(text string) (*Msg, error) {
+	if text == "" {
+		return newCmd(AWAY, false)
+	}
+	return withText(AWAY, text)
+}
+
+// Rehash returns REHASH.
+func Rehash() (*Msg, error) {
+	return newCmd(REHASH, false)
+}
+
+// Die returns DIE.
+func Die() (*Msg, error) {
+	return newCmd(DIE, false)
+}
+
+// Restart returns RESTART.
+func Restart() (*Msg, error) {
+	return newCmd(RESTART, false)
+}
+
+// Summon returns SUMMON user.
+func Summon(user string) (*Msg, error) {
+	return newCmd(SUMMON, false, user)
+}
+
+// Users returns USERS of optional server target.
+func Users(target string) (*Msg, error) {
+	return newCmd(USERS, false, optional(nil, target)...)
+}
+
+// Wallops returns WALLOPS :text.
+func Wallops(text string) (*Msg, error) {
+	return withText(WALLOPS, text)
+}
+
+// Userhost returns USERHOST of nicks, at most 5.
+func Userhost(nicks ...string) (*Msg, error) {
+	if len(nicks) == 0 || len(nicks) > 5 {
+		return nil, errors.New("irc: USERHOST takes 1 to 5 nicks")
+	}
+	return newCmd(USERHOST, false, nicks...)
+}
+
+// Ison returns ISON of nicks.
+func Ison(nicks ...string) (*Msg, error) {
+	if len(nicks) == 0 {
+		return nil, errors.New("irc: ISON needs a nick")
+	}
+	return newCmd(ISON, false, nicks...)
+}
+
+// Server returns SERVER name hopcount :info.
+func Server(name string, hopcount int, info string) (*Msg, error) {
+	return withText(SERVER, info, name, strconv.Itoa(hopcount))
+}
+
+// Njoin returns NJOIN channel :members, members have their prefix like
+// "@@nick".
+func Njoin(channel string, members []string) (*Msg, error) {
+	return withText(NJOIN, strings.Join(members, ","), channel)
+}
+
+// Cap returns CAP subcommand with params, e.g.
+// Cap(CAP_REQ, "sasl multi-prefix").
+func Cap(subcommand string, params ...string) (*Msg, error) {
+	return NewCmd(CAP, append([]string{subcommand}, params...)...)
+}
+
+// Authenticate returns AUTHENTICATE data.
+func Authenticate(data string) (*Msg, error) {
+	return newCmd(AUTHENTICATE, false, data)
+}
+
+// BatchStart returns BATCH which starts batch ref of typ with params.
+func BatchStart(ref, typ string, params ...string) (*Msg, error) {
+	return newCmd(BATCH, false, append([]string{"+" + ref, typ}, params...)...)
+}
+
+// BatchEnd returns BATCH which ends batch ref.
+func BatchEnd(ref string) (*Msg, error) {
+	return newCmd(BATCH, false, "-"+ref)
+}
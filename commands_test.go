@@ -0,0 +1,86 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	must := func(msg *Msg, err error) *Msg {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	for _, c := range []struct {
+		msg  *Msg
+		want string
+	}{
+		{must(NewCmd(PRIVMSG, "#go", "hi")), "PRIVMSG #go hi"},
+		{must(NewCmd(PRIVMSG, "#go", ":)")), "PRIVMSG #go ::)"},
+		{must(Pass("secret")), "PASS secret"},
+		{must(Nick("gopher")), "NICK gopher"},
+		{must(User("gopher", "Go Pher")), "USER gopher 0 * :Go Pher"},
+		{must(Privmsg("#go", "hello world")), "PRIVMSG #go :hello world"},
+		{must(Notice("bob", "hi")), "NOTICE bob :hi"},
+		{must(Join([]string{"#a", "#b", "#c"}, []string{"k1", "k2"})), "JOIN #a,#b,#c k1,k2"},
+		{must(Part([]string{"#a", "#b"}, "")), "PART #a,#b"},
+		{must(Part([]string{"#a"}, "bye now")), "PART #a :bye now"},
+		{must(Mode("#go", []ModeChange{{true, 'o', "bob"}, {true, 'n', ""}, {false, 'k', "key"}})), "MODE #go +on-k bob key"},
+		{must(Mode("#go", nil)), "MODE #go"},
+		{must(Kick("#go", "bob", "")), "KICK #go bob"},
+		{must(Kick("#go", "bob", "spam")), "KICK #go bob :spam"},
+		{must(Topic("#go", "")), "TOPIC #go :"},
+		{must(TopicQuery("#go")), "TOPIC #go"},
+		{must(Invite("bob", "#go")), "INVITE bob #go"},
+		{must(Names()), "NAMES"},
+		{must(List("#a", "#b")), "LIST #a,#b"},
+		{must(Who("#go")), "WHO #go"},
+		{must(Whois("bob")), "WHOIS bob"},
+		{must(Whowas("bob", 2)), "WHOWAS bob 2"},
+		{must(Away("")), "AWAY"},
+		{must(Away("lunch")), "AWAY :lunch"},
+		{must(Quit("")), "QUIT"},
+		{must(Ping("123")), "PING :123"},
+		{must(Pong("irc.example.com")), "PONG :irc.example.com"},
+		{must(Stats("u", "")), "STATS u"},
+		{must(Connect("irc.b", 6667, "")), "CONNECT irc.b 6667"},
+		{must(Userhost("a", "b")), "USERHOST a b"},
+		{must(Ison("a", "b")), "ISON a b"},
+		{must(Cap(CAP_REQ, "sasl multi-prefix")), "CAP REQ :sasl multi-prefix"},
+		{must(Cap(CAP_END)), "CAP END"},
+		{must(Authenticate("+")), "AUTHENTICATE +"},
+		{must(BatchStart("ref", "netsplit", "a.b", "c.d")), "BATCH +ref netsplit a.b c.d"},
+		{must(BatchEnd("ref")), "BATCH -ref"},
+		{must(Njoin("#go", []string{"@@a", "+b"})), "NJOIN #go :@@a,+b"},
+	} {
+		buf := new(bytes.Buffer)
+		if _, err := NewEncoder(buf).Encode(c.msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != c.want+"\r\n" {
+			t.Errorf("got %q want %q", got, c.want)
+		}
+	}
+}
+
+func TestCommandsInvalid(t *testing.T) {
+	for i, f := range []func() (*Msg, error){
+		func() (*Msg, error) { return Privmsg("#go", "a\r\nQUIT") },
+		func() (*Msg, error) { return Privmsg("#go x", "hi") },
+		func() (*Msg, error) { return Privmsg("", "hi") },
+		func() (*Msg, error) { return Nick(":bad") },
+		func() (*Msg, error) { return Nick("a\x00") },
+		func() (*Msg, error) { return Join(nil, nil) },
+		func() (*Msg, error) { return Join([]string{"#a"}, []string{"a", "b"}) },
+		func() (*Msg, error) { return Kick("#go", "bad nick", "") },
+		func() (*Msg, error) { return Mode("#go", []ModeChange{{true, 'k', "a b"}}) },
+		func() (*Msg, error) { return Userhost() },
+		func() (*Msg, error) { return NewCmd("PRIV MSG") },
+		func() (*Msg, error) { return NewCmd(PRIVMSG, make([]string, 17)...) },
+	} {
+		if msg, err := f(); err == nil {
+			t.Errorf("%d: no error for %v", i, msg)
+		}
+	}
+}
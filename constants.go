@@ -244,8 +244,12 @@ const (
 	CAP_NAK   = "NAK"   // Subcommand (param)
 	CAP_CLEAR = "CLEAR" // Subcommand (param)
 	CAP_END   = "END"   // Subcommand (param)
+	CAP_NEW   = "NEW"   // Subcommand (param)
+	CAP_DEL   = "DEL"   // Subcommand (param)
 
 	AUTHENTICATE = "AUTHENTICATE"
+	BATCH        = "BATCH"
+	ACK          = "ACK" // labeled-response without reply
 )
 
 // Numeric IRC replies extracted from the IRCv3 spec.
@@ -295,4 +299,8 @@ const (
 	RPL_TOPICWHOTIME   = "333" // From ircu, in use on Freenode
 	RPL_LOCALUSERS     = "265" // From aircd, Hybrid, Hybrid, Bahamut, in use on Freenode
 	RPL_GLOBALUSERS    = "266" // From aircd, Hybrid, Hybrid, Bahamut, in use on Freenode
+	RPL_STARTTLS       = "670" // Legacy STARTTLS extension
+	ERR_STARTTLS       = "691" // Legacy STARTTLS extension
+
+	STARTTLS = "STARTTLS"
 )
@@ -0,0 +1,188 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// CTCP is a PRIVMSG (request) or NOTICE (reply) whose text is wrapped in
+// \x01, see https://modern.ircdocs.horse/ctcp.html
+const ctcpDelim byte = 0x01
+
+const (
+	CTCP_ACTION     = "ACTION"
+	CTCP_CLIENTINFO = "CLIENTINFO"
+	CTCP_DCC        = "DCC"
+	CTCP_PING       = "PING"
+	CTCP_TIME       = "TIME"
+	CTCP_VERSION    = "VERSION"
+)
+
+// ParseCTCP splits a CTCP payload p into cmd and arg, both are subslices
+// of p. The closing \x01 is optional.
+func ParseCTCP(p []byte) (cmd, arg []byte, ok bool) {
+	if len(p) < 2 || p[0] != ctcpDelim {
+		return
+	}
+	p = p[1:]
+	if p[len(p)-1] == ctcpDelim {
+		p = p[:len(p)-1]
+	}
+	cmd = p
+	if n := bytes.IndexByte(p, space); n >= 0 {
+		cmd, arg = p[:n], p[n+1:]
+	}
+	return cmd, arg, len(cmd) != 0
+}
+
+// CTCP returns cmd and arg of a CTCP PRIVMSG or NOTICE, arg is still
+// low-level quoted, see UnquoteCTCP.
+func (m *Msg) CTCP() (cmd, arg []byte, ok bool) {
+	if c := string(m.cmd); c != PRIVMSG && c != NOTICE {
+		return
+	}
+	text := m.Trailing()
+	if text == nil {
+		// PRIVMSG target text, without ':'
+		if params := m.Params(); len(params) == 2 {
+			text = params[1]
+		}
+	}
+	return ParseCTCP(text)
+}
+
+// IsCTCPReply reports whether msg is a CTCP reply.
+func (m *Msg) IsCTCPReply() bool {
+	_, _, ok := m.CTCP()
+	return ok && string(m.cmd) == NOTICE
+}
+
+// AppendCTCP appends the CTCP payload of cmd and arg to dst, arg is
+// low-level quoted.
+func AppendCTCP(dst []byte, cmd, arg string) []byte {
+	dst = append(dst, ctcpDelim)
+	dst = append(dst, cmd...)
+	if arg != "" {
+		dst = append(dst, space)
+		dst = QuoteCTCP(dst, []byte(arg))
+	}
+	return append(dst, ctcpDelim)
+}
+
+// CTCPRequest returns a PRIVMSG of CTCP cmd to target.
+func CTCPRequest(target, cmd, arg string) *Msg {
+	return newCTCP(PRIVMSG, target, cmd, arg)
+}
+
+// CTCPReply returns a NOTICE of CTCP cmd to target.
+func CTCPReply(target, cmd, arg string) *Msg {
+	return newCTCP(NOTICE, target, cmd, arg)
+}
+
+func newCTCP(kind, target, cmd, arg string) *Msg {
+	msg := new(Msg)
+	msg.SetCmd([]byte(kind))
+	msg.AppendParams([]byte(target))
+	msg.SetTrailing(AppendCTCP(nil, cmd, arg))
+	return msg
+}
+
+// Low-level quoting of the original CTCP spec, which keeps NUL, CR and LF
+// out of a line.
+const ctcpQuote byte = 0x10
+
+// QuoteCTCP appends p with NUL, CR, LF and \x10 quoted to dst.
+func QuoteCTCP(dst, p []byte) []byte {
+	for _, b := range p {
+		switch b {
+		case 0:
+			dst = append(dst, ctcpQuote, '0')
+		case '\n':
+			dst = append(dst, ctcpQuote, 'n')
+		case '\r':
+			dst = append(dst, ctcpQuote, 'r')
+		case ctcpQuote:
+			dst = append(dst, ctcpQuote, ctcpQuote)
+		default:
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// UnquoteCTCP appends p with low-level quoting removed to dst, a quote
+// before any other byte is dropped.
+func UnquoteCTCP(dst, p []byte) []byte {
+	for i := 0; i < len(p); i++ {
+		if p[i] != ctcpQuote {
+			dst = append(dst, p[i])
+			continue
+		}
+		if i++; i == len(p) {
+			break
+		}
+		switch p[i] {
+		case '0':
+			dst = append(dst, 0)
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case ctcpQuote:
+			dst = append(dst, ctcpQuote)
+		default:
+			dst = append(dst, p[i])
+		}
+	}
+	return dst
+}
+
+// CTCPResponder answers CTCP VERSION, PING, TIME and CLIENTINFO requests,
+// other msgs are passed to Handler.
+type CTCPResponder struct {
+	// Version is the VERSION reply, VERSION is ignored if empty.
+	Version string
+	Clock   Clock
+	// Handler may be nil.
+	Handler Handler
+}
+
+func NewCTCPResponder(version string, h Handler) *CTCPResponder {
+	return &CTCPResponder{Version: version, Clock: SystemClock, Handler: h}
+}
+
+// ClientInfo returns the CLIENTINFO reply.
+func (r *CTCPResponder) ClientInfo() string {
+	cmds := []string{CTCP_ACTION, CTCP_CLIENTINFO, CTCP_PING, CTCP_TIME}
+	if r.Version != "" {
+		cmds = append(cmds, CTCP_VERSION)
+	}
+	return strings.Join(cmds, " ")
+}
+
+func (r *CTCPResponder) ServeIRC(w Writer, msg *Msg) {
+	cmd, arg, ok := msg.CTCP()
+	if ok && string(msg.Cmd()) == PRIVMSG && len(msg.Name()) != 0 {
+		reply, answered := "", true
+		switch string(cmd) {
+		case CTCP_VERSION:
+			reply, answered = r.Version, r.Version != ""
+		case CTCP_PING:
+			reply = string(UnquoteCTCP(nil, arg))
+		case CTCP_TIME:
+			reply = r.Clock.Now().Format(time.RFC1123Z)
+		case CTCP_CLIENTINFO:
+			reply = r.ClientInfo()
+		default:
+			answered = false
+		}
+		if answered {
+			w.Encode(CTCPReply(string(msg.Name()), string(cmd), reply))
+			return
+		}
+	}
+	if r.Handler != nil {
+		r.Handler.ServeIRC(w, msg)
+	}
+}
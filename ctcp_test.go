@@ -0,0 +1,108 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseCTCP(t *testing.T) {
+	for _, c := range []struct {
+		raw      string
+		cmd, arg string
+		ok       bool
+	}{
+		{":a!b@c PRIVMSG #go :\x01ACTION waves hello\x01", "ACTION", "waves hello", true},
+		{":a!b@c PRIVMSG me :\x01VERSION\x01", "VERSION", "", true},
+		{":a!b@c NOTICE me :\x01PING 123", "PING", "123", true},
+		{":a!b@c PRIVMSG me \x01TIME\x01", "TIME", "", true},
+		{":a!b@c PRIVMSG me :hello", "", "", false},
+		{":a!b@c PRIVMSG me :\x01\x01", "", "", false},
+		{":a!b@c TOPIC #go :\x01ACTION\x01", "", "", false},
+	} {
+		cmd, arg, ok := newTestMsg(c.raw).CTCP()
+		if string(cmd) != c.cmd || string(arg) != c.arg || ok != c.ok {
+			t.Errorf("%q got %q %q %v", c.raw, cmd, arg, ok)
+		}
+	}
+
+	msg := newTestMsg(":a!b@c PRIVMSG me :\x01ACTION waves\x01")
+	n := testing.AllocsPerRun(100, func() {
+		msg.CTCP()
+	})
+	if n != 0 {
+		t.Errorf("%v allocs", n)
+	}
+}
+
+func TestQuoteCTCP(t *testing.T) {
+	raw := []byte("a\x00b\r\nc\x10d")
+	quoted := QuoteCTCP(nil, raw)
+	if string(quoted) != "a\x100b\x10r\x10nc\x10\x10d" {
+		t.Errorf("%q", quoted)
+	}
+	if p := UnquoteCTCP(nil, quoted); !bytes.Equal(p, raw) {
+		t.Errorf("%q", p)
+	}
+	if p := UnquoteCTCP(nil, []byte("\x10x\x10")); string(p) != "x" {
+		t.Errorf("%q", p)
+	}
+}
+
+func TestCTCPBuild(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.Encode(CTCPRequest("#go", CTCP_ACTION, "waves\r\n"))
+	enc.Encode(CTCPReply("bob", CTCP_VERSION, ""))
+	want := "PRIVMSG #go :\x01ACTION waves\x10r\x10n\x01\r\nNOTICE bob :\x01VERSION\x01\r\n"
+	if buf.String() != want {
+		t.Errorf("%q", buf.String())
+	}
+
+	msg := newTestMsg("NOTICE bob :\x01VERSION irc\x01")
+	if !msg.IsCTCPReply() {
+		t.Error("not reply")
+	}
+}
+
+func TestCTCPResponder(t *testing.T) {
+	var passed []string
+	r := NewCTCPResponder("irc 1.0", HandlerFunc(func(w Writer, msg *Msg) {
+		passed = append(passed, msg.String())
+	}))
+	clock := newFakeClock()
+	r.Clock = clock
+
+	buf := new(lockedBuffer)
+	enc := NewEncoder(buf)
+	for _, raw := range []string{
+		":bob!b@h PRIVMSG me :\x01VERSION\x01",
+		":bob!b@h PRIVMSG me :\x01PING 1\x10n2\x01",
+		":bob!b@h PRIVMSG me :\x01TIME\x01",
+		":bob!b@h PRIVMSG me :\x01CLIENTINFO\x01",
+		":bob!b@h PRIVMSG me :\x01ACTION waves\x01",
+		":bob!b@h NOTICE me :\x01VERSION other\x01",
+		":bob!b@h PRIVMSG me :hi",
+	} {
+		r.ServeIRC(enc, newTestMsg(raw))
+	}
+
+	want := []string{
+		"NOTICE bob :\x01VERSION irc 1.0\x01",
+		"NOTICE bob :\x01PING 1\x10n2\x01",
+		"NOTICE bob :\x01TIME " + clock.Now().Format(time.RFC1123Z) + "\x01",
+		"NOTICE bob :\x01CLIENTINFO ACTION CLIENTINFO PING TIME VERSION\x01",
+	}
+	lines := buf.Lines()
+	if len(lines) != len(want) {
+		t.Fatal(lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("%q want %q", lines[i], want[i])
+		}
+	}
+	if len(passed) != 3 {
+		t.Error(passed)
+	}
+}
@@ -2,32 +2,105 @@ package irc
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"sync"
 )
 
+// MaxTagsLen is the max length of tags including '@' and the trailing space.
+const MaxTagsLen = 8191
+
+var ErrLineTooLong = errors.New("irc: line too long")
+
 type Decoder struct {
 	rdr *bufio.Reader
 	*sync.Mutex
+
+	// MaxLen is the max length of a line without tags including CRLF,
+	// MaxTagsLen is the max length of tags. Both can not be larger than
+	// defaults.
+	MaxLen     int
+	MaxTagsLen int
+
+	// DiscardLong skips overlong lines instead of returning ErrLineTooLong.
+	DiscardLong bool
+
+	// Clock stamps decoded msgs with their receive time if not nil.
+	Clock Clock
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	rdr := bufio.NewReader(r)
-	return &Decoder{rdr, &sync.Mutex{}}
+	rdr := bufio.NewReaderSize(r, MaxTagsLen+MaxLineLen)
+	return &Decoder{rdr, &sync.Mutex{}, MaxLineLen, MaxTagsLen, false, nil}
 }
 
-// Decode msg from reader
+// Decode msg from reader, lines end with "\r\n" or "\n" and empty lines
+// are skipped.
 func (d *Decoder) Decode(msg *Msg) (err error) {
 	d.Lock()
 	defer d.Unlock()
 
 	var line []byte
-	line, _, err = d.rdr.ReadLine()
-	if err != nil {
-		return
+	for {
+		line, err = d.readLine()
+		if err == ErrLineTooLong && d.DiscardLong {
+			continue
+		}
+		if err != nil {
+			msg.Reset()
+			return
+		}
+		if len(line) != 0 {
+			break
+		}
 	}
 
 	msg.Reset()
+	if d.Clock != nil {
+		msg.received = d.Clock.Now()
+	}
 	msg.Data = line[:]
 	return msg.PeekCmd()
 }
+
+func (d *Decoder) readLine() (line []byte, err error) {
+	line, err = d.rdr.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// drop the rest of line
+		for err == bufio.ErrBufferFull {
+			_, err = d.rdr.ReadSlice('\n')
+		}
+		if err == nil || err == io.EOF {
+			err = ErrLineTooLong
+		}
+		return nil, err
+	}
+	if err == io.EOF && len(line) != 0 {
+		// last line without terminator
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+
+	rest := line
+	if len(line) != 0 && line[0] == tagsSymbol {
+		n := bytes.IndexByte(line, space)
+		if n+1 > d.MaxTagsLen {
+			return nil, ErrLineTooLong
+		}
+		rest = line[n+1:]
+	}
+	if len(rest)+2 > d.MaxLen {
+		return nil, ErrLineTooLong
+	}
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 )
 
@@ -54,3 +55,56 @@ func BenchmarkDecoder(b *testing.B) {
 		}
 	}
 }
+
+func TestDecodeTerminators(t *testing.T) {
+	buf := bytes.NewBufferString("PING a\r\nPING b\n\r\n\nPING c")
+	dec := NewDecoder(buf)
+	msg := new(Msg)
+	for _, want := range []string{"PING a", "PING b", "PING c"} {
+		if err := dec.Decode(msg); err != nil || string(msg.Data) != want {
+			t.Errorf("want %q got %q %v", want, msg.Data, err)
+		}
+	}
+	if err := dec.Decode(msg); err != io.EOF {
+		t.Error(err)
+	}
+}
+
+func TestDecodeLineTooLong(t *testing.T) {
+	long := "PRIVMSG #chan :" + strings.Repeat("x", 600)
+	tags := "@" + strings.Repeat("t", 8000) + " PING ok"
+	huge := "PRIVMSG #chan :" + strings.Repeat("x", 10000)
+	buf := bytes.NewBufferString(long + "\r\nPING a\r\n" + tags + "\r\n" + huge + "\r\nPING b\r\n")
+
+	dec := NewDecoder(buf)
+	msg := new(Msg)
+	for i, want := range []string{"", "PING", "PING", "", "PING"} {
+		err := dec.Decode(msg)
+		if want == "" {
+			if err != ErrLineTooLong {
+				t.Error(i, err)
+			}
+			continue
+		}
+		if err != nil || string(msg.Cmd()) != want {
+			t.Error(i, err, msg)
+		}
+	}
+}
+
+func TestDecodeDiscardLong(t *testing.T) {
+	long := "PRIVMSG #chan :" + strings.Repeat("x", 10000)
+	buf := bytes.NewBufferString(long + "\r\n" + long + "\nPING a\r\n")
+	dec := NewDecoder(buf)
+	dec.DiscardLong = true
+	msg := new(Msg)
+	if err := dec.Decode(msg); err != nil || string(msg.Data) != "PING a" {
+		t.Error(err, msg)
+	}
+
+	dec = NewDecoder(bytes.NewBufferString("PING " + strings.Repeat("x", 600)))
+	dec.DiscardLong = true
+	if err := dec.Decode(msg); err != io.EOF {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,191 @@
+package irc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Dialer connects to IRC servers by plain TCP, implicit TLS or the legacy
+// STARTTLS upgrade, the returned connection is ready for NewClient.
+type Dialer struct {
+	// TLS connects with implicit TLS, usually to port 6697.
+	TLS bool
+
+	// StartTLS upgrades a plain connection by STARTTLS before
+	// registration, the server answers RPL_STARTTLS or ERR_STARTTLS.
+	// TLS takes precedence.
+	StartTLS bool
+
+	// TLSConfig is used for TLS and StartTLS, ServerName defaults to the
+	// host of addr.
+	TLSConfig *tls.Config
+
+	// Certificate is presented to server, e.g. for CertFP and SASL
+	// EXTERNAL.
+	Certificate *tls.Certificate
+
+	// Fingerprint pins the SHA-256 fingerprint of the server certificate
+	// in hex, colons are allowed. The certificate is not verified against
+	// CAs if set, so self-signed certificates are accepted.
+	Fingerprint string
+
+	// Timeout of connecting, STARTTLS and TLS handshake, zero means none.
+	Timeout time.Duration
+}
+
+// Fingerprint returns SHA-256 fingerprint of DER encoded certificate in
+// lowercase hex, e.g. of x509.Certificate.Raw.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// Dial connects to addr.
+func (d *Dialer) Dial(addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), addr)
+}
+
+// DialContext connects to addr with ctx.
+func (d *Dialer) DialContext(ctx context.Context, addr string) (conn net.Conn, err error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	var nd net.Dialer
+	conn, err = nd.DialContext(ctx, "tcp", addr)
+	if err != nil || !d.TLS && !d.StartTLS {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}()
+
+	cfg, err := d.tlsConfig(addr)
+	if err != nil {
+		return
+	}
+	if !d.TLS {
+		if err = startTLS(ctx, conn); err != nil {
+			return
+		}
+	}
+	tc := tls.Client(conn, cfg)
+	if err = tc.HandshakeContext(ctx); err != nil {
+		return
+	}
+	return tc, nil
+}
+
+func (d *Dialer) tlsConfig(addr string) (cfg *tls.Config, err error) {
+	if d.TLSConfig != nil {
+		cfg = d.TLSConfig.Clone()
+	} else {
+		cfg = new(tls.Config)
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	if d.Certificate != nil {
+		cfg.Certificates = append(cfg.Certificates, *d.Certificate)
+	}
+
+	if d.Fingerprint != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(d.Fingerprint, ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, errors.New("irc: invalid SHA-256 fingerprint " + d.Fingerprint)
+		}
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("irc: server sent no certificate")
+			}
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if subtle.ConstantTimeCompare(got[:], want) != 1 {
+				return errors.New("irc: certificate fingerprint mismatch, got " + hex.EncodeToString(got[:]))
+			}
+			return nil
+		}
+	}
+	return
+}
+
+// aLongTimeAgo is a deadline which fails blocked I/O at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// startTLS sends STARTTLS and reads until RPL_STARTTLS. Lines are read
+// byte by byte so no TLS record is consumed.
+func startTLS(ctx context.Context, conn net.Conn) (err error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	// unblock reads on cancel
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(aLongTimeAgo) })
+	defer func() {
+		if !stop() && err != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	msg, err := NewCmd(STARTTLS)
+	if err != nil {
+		return
+	}
+	if _, err = NewEncoder(conn).Encode(msg); err != nil {
+		return
+	}
+
+	line := make([]byte, 0, MaxLineLen)
+	b := make([]byte, 1)
+	for {
+		line = line[:0]
+		for {
+			if _, err = conn.Read(b); err != nil {
+				return
+			}
+			if b[0] == '\n' {
+				break
+			}
+			if len(line) >= MaxTagsLen+MaxLineLen {
+				return ErrLineTooLong
+			}
+			line = append(line, b[0])
+		}
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+
+		reply, err := NewMsg(line)
+		if err != nil {
+			return err
+		}
+		switch string(reply.Cmd()) {
+		case RPL_STARTTLS:
+			return nil
+		case ERR_STARTTLS:
+			return errors.New("irc: STARTTLS failed: " + string(reply.Trailing()))
+		case ERR_UNKNOWNCOMMAND, ERR_NOTREGISTERED:
+			return errors.New("irc: server does not support STARTTLS")
+		case ERROR:
+			return errors.New("ERROR " + string(reply.Trailing()))
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLS accepts one connection of ln, reads a line by TLS and sends it
+// to lines with the client certificate fingerprint.
+func serveTLS(t *testing.T, ln net.Listener, cfg *tls.Config, startTLS string) <-chan string {
+	lines := make(chan string, 1)
+	go func() {
+		defer close(lines)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if startTLS != "" {
+			rdr := bufio.NewReader(conn)
+			if l, _ := rdr.ReadString('\n'); l != "STARTTLS\r\n" {
+				t.Errorf("got %q", l)
+				return
+			}
+			conn.Write([]byte(":srv NOTICE * :*** Looking up your hostname\r\n" + startTLS + "\r\n"))
+			if !strings.Contains(startTLS, RPL_STARTTLS) {
+				return
+			}
+		}
+		tc := tls.Server(conn, cfg)
+		l, err := bufio.NewReader(tc).ReadString('\n')
+		if err != nil {
+			return
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			l += Fingerprint(certs[0].Raw)
+		}
+		lines <- l
+	}()
+	return lines
+}
+
+func TestDialTLSFingerprint(t *testing.T) {
+	cert := newTestCert(t, "irc.example")
+	fp := Fingerprint(cert.Certificate[0])
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	for _, c := range []struct {
+		fp string
+		ok bool
+	}{
+		{fp, true},
+		{strings.ToUpper(fp[:2]) + ":" + fp[2:], true},
+		{strings.Repeat("00", 32), false},
+		{"", false}, // self-signed fails verification
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := serveTLS(t, ln, cfg, "")
+
+		d := &Dialer{TLS: true, Fingerprint: c.fp, Timeout: time.Second}
+		conn, err := d.Dial(ln.Addr().String())
+		if (err == nil) != c.ok {
+			t.Errorf("fingerprint %q: %v", c.fp, err)
+		}
+		if err == nil {
+			conn.Write([]byte("NICK bot\r\n"))
+			if l := <-lines; l != "NICK bot\r\n" {
+				t.Errorf("got %q", l)
+			}
+			conn.Close()
+		}
+		ln.Close()
+	}
+}
+
+func TestDialTLSClientCert(t *testing.T) {
+	srvCert, cliCert := newTestCert(t, "irc.example"), newTestCert(t, "bot")
+	pool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(srvCert.Certificate[0])
+	pool.AddCert(leaf)
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := serveTLS(t, ln, cfg, "")
+
+	d := &Dialer{
+		TLS:         true,
+		TLSConfig:   &tls.Config{RootCAs: pool},
+		Certificate: &cliCert,
+	}
+	conn, err := d.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("CAP LS 302\n"))
+	if l := <-lines; l != "CAP LS 302\n"+Fingerprint(cliCert.Certificate[0]) {
+		t.Errorf("got %q", l)
+	}
+}
+
+func TestDialStartTLS(t *testing.T) {
+	cert := newTestCert(t, "irc.example")
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	d := &Dialer{StartTLS: true, Fingerprint: Fingerprint(cert.Certificate[0]), Timeout: time.Second}
+
+	for _, c := range []struct {
+		reply string
+		err   string
+	}{
+		{":srv 670 * :STARTTLS successful, go ahead with TLS handshake", ""},
+		{":srv 691 * :STARTTLS failed", "STARTTLS failed"},
+		{":srv 421 * STARTTLS :Unknown command", "does not support"},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := serveTLS(t, ln, cfg, c.reply)
+
+		conn, err := d.Dial(ln.Addr().String())
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: %v", c.reply, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", c.reply, err)
+		} else {
+			conn.Write([]byte("NICK bot\r\n"))
+			if l := <-lines; l != "NICK bot\r\n" {
+				t.Errorf("got %q", l)
+			}
+			conn.Close()
+		}
+		ln.Close()
+	}
+}
+
+func TestDialStartTLSCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// accept and never answer STARTTLS
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	d := &Dialer{StartTLS: true}
+	if _, err := d.DialContext(ctx, ln.Addr().String()); err != context.Canceled {
+		t.Error(err)
+	}
+}
@@ -12,12 +12,16 @@ type Encoder struct {
 	w   io.Writer
 	buf []byte
 	*sync.Mutex
+
+	// ServerTime attaches its time as time tag to msgs without one if not
+	// nil, e.g. for clients which enabled the server-time cap.
+	ServerTime Clock
 }
 
 func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{w,
 		make([]byte, DefaultEncoderBufferrSize),
-		&sync.Mutex{}}
+		&sync.Mutex{}, nil}
 }
 
 func (e *Encoder) appendByte(b byte) {
@@ -34,9 +38,35 @@ func (e *Encoder) Encode(msg *Msg) (n int, err error) {
 	defer e.Unlock()
 
 	e.buf = e.buf[:0]
+	if err = e.encode(msg); err != nil {
+		return
+	}
+	return e.w.Write(e.buf)
+}
 
+// encode appends msg to buf
+func (e *Encoder) encode(msg *Msg) (err error) {
 	if msg.cmd == nil {
-		return 0, errors.New("no command")
+		return errors.New("no command")
+	}
+
+	stamp := false
+	if e.ServerTime != nil {
+		_, ok := msg.Tag(timeTag)
+		stamp = !ok
+	}
+	if stamp || len(msg.tags) != 0 {
+		e.appendByte(tagsSymbol)
+		if stamp {
+			e.append(timeTag)
+			e.appendByte(tagValueSep)
+			e.buf = AppendServerTime(e.buf, e.ServerTime.Now())
+			if len(msg.tags) != 0 {
+				e.appendByte(tagSep)
+			}
+		}
+		e.append(msg.tags)
+		e.appendByte(space)
 	}
 
 	if msg.prefix != nil {
@@ -64,5 +94,5 @@ func (e *Encoder) Encode(msg *Msg) (n int, err error) {
 	}
 
 	e.append([]byte("\r\n"))
-	return e.w.Write(e.buf)
+	return
 }
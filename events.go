@@ -0,0 +1,349 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Event is a typed event converted from a msg by ParseEvent, one of
+// Joined, Parted, Kicked, UserQuit, NickChanged, TopicChanged, ModeChanged,
+// Invited, ChannelMessage, PrivateMessage and CTCPEvent.
+type Event interface {
+	event()
+}
+
+// Source is the sender of a msg.
+type Source struct {
+	Nick string // or server name
+	User string
+	Host string
+}
+
+func sourceOf(msg *Msg) Source {
+	return Source{string(msg.Name()), string(msg.User()), string(msg.Host())}
+}
+
+// Joined is JOIN of Nick to Channel, Account and RealName are sent with
+// extended-join.
+type Joined struct {
+	Source
+	Channel  string
+	Account  string
+	RealName string
+}
+
+// Parted is PART of Nick from Channel.
+type Parted struct {
+	Source
+	Channel string
+	Reason  string
+}
+
+// Kicked is KICK of Nick from Channel by By.
+type Kicked struct {
+	Channel string
+	Nick    string
+	By      Source
+	Reason  string
+}
+
+// UserQuit is QUIT of Nick.
+type UserQuit struct {
+	Source
+	Reason string
+}
+
+// NickChanged is NICK from Old to New.
+type NickChanged struct {
+	Source // of Old
+	Old    string
+	New    string
+}
+
+// TopicChanged is TOPIC of Channel set by By.
+type TopicChanged struct {
+	Channel string
+	Topic   string
+	By      Source
+}
+
+// ModeChanged is MODE of channel or nick Target set by By.
+type ModeChanged struct {
+	Target  string
+	Changes []ModeChange
+	By      Source
+}
+
+// Invited is INVITE of Nick to Channel by By.
+type Invited struct {
+	Channel string
+	Nick    string
+	By      Source
+}
+
+// ChannelMessage is PRIVMSG or NOTICE to Channel, Status holds the STATUSMSG
+// prefix like "@" if the msg was sent to members with that status only.
+type ChannelMessage struct {
+	Source
+	Channel string
+	Status  string
+	Text    string
+	Notice  bool
+	Action  bool // CTCP ACTION, Text is the action
+}
+
+// PrivateMessage is PRIVMSG or NOTICE to Target which is not a channel,
+// usually the client nick.
+type PrivateMessage struct {
+	Source
+	Target string
+	Text   string
+	Notice bool
+	Action bool // CTCP ACTION, Text is the action
+}
+
+// CTCPEvent is a CTCP request or reply other than ACTION.
+type CTCPEvent struct {
+	Source
+	Target  string
+	Command string
+	Arg     string
+	Reply   bool
+}
+
+func (Joined) event()         {}
+func (Parted) event()         {}
+func (Kicked) event()         {}
+func (UserQuit) event()       {}
+func (NickChanged) event()    {}
+func (TopicChanged) event()   {}
+func (ModeChanged) event()    {}
+func (Invited) event()        {}
+func (ChannelMessage) event() {}
+func (PrivateMessage) event() {}
+func (CTCPEvent) event()      {}
+
+// ParseEvent converts msg into an event, ok is false for msgs without
+// event. s tells channels and modes apart.
+func ParseEvent(s *ISupport, msg *Msg) (e Event, ok bool) {
+	arg, nargs := msg.arg, msg.argCount()
+
+	src := sourceOf(msg)
+	switch string(msg.Cmd()) {
+	case JOIN:
+		if nargs < 1 {
+			return
+		}
+		j := Joined{Source: src, Channel: arg(0)}
+		if nargs >= 3 {
+			j.Account, j.RealName = arg(1), arg(2)
+			if j.Account == "*" {
+				j.Account = ""
+			}
+		}
+		return j, true
+	case PART:
+		if nargs < 1 {
+			return
+		}
+		return Parted{src, arg(0), arg(1)}, true
+	case KICK:
+		if nargs < 2 {
+			return
+		}
+		return Kicked{arg(0), arg(1), src, arg(2)}, true
+	case QUIT:
+		return UserQuit{src, arg(0)}, true
+	case NICK:
+		if nargs < 1 {
+			return
+		}
+		return NickChanged{src, src.Nick, arg(0)}, true
+	case TOPIC:
+		if nargs < 1 {
+			return
+		}
+		return TopicChanged{arg(0), arg(1), src}, true
+	case MODE:
+		target, changes, err := ParseModeMsg(s, msg)
+		if err != nil {
+			return
+		}
+		return ModeChanged{target, changes, src}, true
+	case INVITE:
+		if nargs < 2 {
+			return
+		}
+		return Invited{arg(1), arg(0), src}, true
+	case PRIVMSG, NOTICE:
+		if nargs < 2 {
+			return
+		}
+		return messageEvent(s, msg, src, arg(0), arg(nargs-1))
+	}
+	return
+}
+
+func messageEvent(s *ISupport, msg *Msg, src Source, target, text string) (Event, bool) {
+	notice, action := string(msg.Cmd()) == NOTICE, false
+	if cmd, a, ok := msg.CTCP(); ok {
+		if string(cmd) != CTCP_ACTION {
+			return CTCPEvent{src, target, string(cmd), string(a), notice}, true
+		}
+		text, action = string(a), true
+	}
+
+	status := target
+	target = strings.TrimLeft(target, s.StatusMsg())
+	status = status[:len(status)-len(target)]
+	if s.IsChannel(target) {
+		return ChannelMessage{src, target, status, text, notice, action}, true
+	}
+	return PrivateMessage{src, status + target, text, notice, action}, true
+}
+
+// SlowPolicy tells EventBus what to do when the buffer of a subscriber is
+// full.
+type SlowPolicy int
+
+const (
+	DropNewest SlowPolicy = iota // drop the event for the subscriber
+	DropOldest                   // drop the oldest buffered event
+	Block                        // wait until the subscriber receives
+	Evict                        // unsubscribe and close the channel
+)
+
+// Subscription receives events from C until it is unsubscribed.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	policy  SlowPolicy
+	filter  func(Event) bool
+	done    chan struct{} // closed by unsubscribe
+	once    sync.Once
+	mu      sync.Mutex // serializes sends and close of c
+	closed  bool
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of events dropped for s.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// EventBus converts msgs into events and delivers them to subscribers,
+// use it as Handler. Publish delivers in order, a Block subscriber delays
+// the others.
+type EventBus struct {
+	ISupport *ISupport
+
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+// NewEventBus returns a bus which parses with s, e.g. Client.ISupport().
+func NewEventBus(s *ISupport) *EventBus {
+	if s == nil {
+		s = NewISupport()
+	}
+	return &EventBus{ISupport: s}
+}
+
+// Subscribe returns a subscription buffered by n with policy, filter
+// selects events if not nil.
+func (b *EventBus) Subscribe(n int, policy SlowPolicy, filter func(Event) bool) *Subscription {
+	c := make(chan Event, n)
+	s := &Subscription{C: c, c: c, policy: policy, filter: filter, done: make(chan struct{})}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes s and closes s.C, buffered events are still received.
+func (b *EventBus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.done) }) // wakes a blocked send
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+// ServeIRC publishes the event of msg.
+func (b *EventBus) ServeIRC(w Writer, msg *Msg) {
+	if e, ok := ParseEvent(b.ISupport, msg); ok {
+		b.Publish(e)
+	}
+}
+
+// Publish delivers e to subscribers.
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	subs := append([]*Subscription(nil), b.subs...)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		if !s.send(e) {
+			b.Unsubscribe(s)
+		}
+	}
+}
+
+// send delivers e by policy, it returns false if s should be unsubscribed.
+func (s *Subscription) send(e Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.c <- e:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.c:
+			s.dropped.Add(1)
+		default:
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	case Block:
+		select {
+		case s.c <- e:
+		case <-s.done:
+			s.dropped.Add(1)
+		}
+	case Evict:
+		s.dropped.Add(1)
+		return false
+	default:
+		s.dropped.Add(1)
+	}
+	return true
+}
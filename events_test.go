@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseEvent(t *testing.T) {
+	s := newISupport(t, ":srv 005 me STATUSMSG=@+ CHANTYPES=#& :are supported")
+	bob := Source{"bob", "b", "host"}
+	for _, c := range []struct {
+		raw  string
+		want Event
+	}{
+		{":bob!b@host JOIN #go", Joined{Source: bob, Channel: "#go"}},
+		{":bob!b@host JOIN #go bobacct :Bob Smith", Joined{bob, "#go", "bobacct", "Bob Smith"}},
+		{":bob!b@host JOIN #go * :Bob Smith", Joined{bob, "#go", "", "Bob Smith"}},
+		{":bob!b@host PART #go :bye", Parted{bob, "#go", "bye"}},
+		{":bob!b@host KICK #go alice :spam", Kicked{"#go", "alice", bob, "spam"}},
+		{":bob!b@host QUIT :Ping timeout", UserQuit{bob, "Ping timeout"}},
+		{":bob!b@host NICK :robert", NickChanged{bob, "bob", "robert"}},
+		{":bob!b@host TOPIC #go :Go talk", TopicChanged{"#go", "Go talk", bob}},
+		{":bob!b@host MODE #go +o-v alice carol", ModeChanged{"#go", []ModeChange{{true, 'o', "alice"}, {false, 'v', "carol"}}, bob}},
+		{":srv MODE bob +o", ModeChanged{"bob", []ModeChange{{true, 'o', ""}}, Source{"srv", "", ""}}},
+		{":bob!b@host INVITE me #go", Invited{"#go", "me", bob}},
+		{":bob!b@host PRIVMSG #go :hello", ChannelMessage{bob, "#go", "", "hello", false, false}},
+		{":bob!b@host PRIVMSG @#go :ops only", ChannelMessage{bob, "#go", "@", "ops only", false, false}},
+		{":bob!b@host NOTICE &local :hi", ChannelMessage{bob, "&local", "", "hi", true, false}},
+		{":bob!b@host PRIVMSG #go :\x01ACTION waves\x01", ChannelMessage{bob, "#go", "", "waves", false, true}},
+		{":bob!b@host PRIVMSG me :psst", PrivateMessage{bob, "me", "psst", false, false}},
+		{":srv NOTICE me :*** Looking up your hostname", PrivateMessage{Source{"srv", "", ""}, "me", "*** Looking up your hostname", true, false}},
+		{":bob!b@host PRIVMSG me :\x01VERSION\x01", CTCPEvent{bob, "me", "VERSION", "", false}},
+		{":bob!b@host NOTICE me :\x01PING 123\x01", CTCPEvent{bob, "me", "PING", "123", true}},
+	} {
+		e, ok := ParseEvent(s, newTestMsg(c.raw))
+		if !ok || !reflect.DeepEqual(e, c.want) {
+			t.Errorf("%q = %#v, want %#v", c.raw, e, c.want)
+		}
+	}
+
+	for _, raw := range []string{":srv 001 me :Welcome", "PING :srv", ":bob!b@host KICK #go"} {
+		if e, ok := ParseEvent(s, newTestMsg(raw)); ok {
+			t.Errorf("%q = %#v", raw, e)
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	b := NewEventBus(nil)
+	all := b.Subscribe(10, DropNewest, nil)
+	joins := b.Subscribe(10, DropNewest, func(e Event) bool {
+		_, ok := e.(Joined)
+		return ok
+	})
+
+	b.ServeIRC(nil, newTestMsg(":bob!b@host JOIN #go"))
+	b.ServeIRC(nil, newTestMsg("PING :srv"))
+	b.ServeIRC(nil, newTestMsg(":bob!b@host PRIVMSG #go :hi"))
+
+	if e := <-all.C; e.(Joined).Channel != "#go" {
+		t.Error(e)
+	}
+	if e := <-all.C; e.(ChannelMessage).Text != "hi" {
+		t.Error(e)
+	}
+	if e := <-joins.C; e.(Joined).Nick != "bob" {
+		t.Error(e)
+	}
+	if len(joins.C) != 0 {
+		t.Error("filter passed", <-joins.C)
+	}
+
+	b.Unsubscribe(all)
+	b.Unsubscribe(all)
+	if _, ok := <-all.C; ok {
+		t.Error("not closed")
+	}
+	b.Publish(UserQuit{})
+	if len(joins.C) != 0 {
+		t.Error("filtered event", <-joins.C)
+	}
+}
+
+func TestEventBusSlow(t *testing.T) {
+	b := NewEventBus(nil)
+	newest := b.Subscribe(2, DropNewest, nil)
+	oldest := b.Subscribe(2, DropOldest, nil)
+	evict := b.Subscribe(2, Evict, nil)
+	for i := 0; i < 4; i++ {
+		b.Publish(Parted{Reason: string(rune('a' + i))})
+	}
+
+	recv := func(s *Subscription) (got string) {
+		for len(s.C) > 0 {
+			if e, ok := <-s.C; ok {
+				got += e.(Parted).Reason
+			}
+		}
+		return
+	}
+	if got := recv(newest); got != "ab" || newest.Dropped() != 2 {
+		t.Error("DropNewest", got, newest.Dropped())
+	}
+	if got := recv(oldest); got != "cd" || oldest.Dropped() != 2 {
+		t.Error("DropOldest", got, oldest.Dropped())
+	}
+	if got := recv(evict); got != "ab" || evict.Dropped() != 1 {
+		t.Error("Evict", got, evict.Dropped())
+	}
+	if _, ok := <-evict.C; ok {
+		t.Error("evicted not closed")
+	}
+
+	// a blocking subscriber delays Publish until it receives or leaves
+	block := b.Subscribe(0, Block, nil)
+	done := make(chan struct{})
+	go func() {
+		b.Publish(Parted{Reason: "x"})
+		b.Publish(Parted{Reason: "y"})
+		close(done)
+	}()
+	if e := <-block.C; e.(Parted).Reason != "x" {
+		t.Error(e)
+	}
+	select {
+	case <-done:
+		t.Fatal("Publish did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.Unsubscribe(block)
+	<-done
+	if block.Dropped() != 1 {
+		t.Error(block.Dropped())
+	}
+}
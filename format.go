@@ -0,0 +1,562 @@
+package irc
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// mIRC formatting codes, see https://modern.ircdocs.horse/formatting.html
+const (
+	boldCode          byte = 0x02
+	colorCode         byte = 0x03
+	hexColorCode      byte = 0x04
+	resetCode         byte = 0x0f
+	monospaceCode     byte = 0x11
+	reverseCode       byte = 0x16
+	italicCode        byte = 0x1d
+	strikethroughCode byte = 0x1e
+	underlineCode     byte = 0x1f
+)
+
+// Attr is a set of text attributes.
+type Attr uint8
+
+const (
+	Bold Attr = 1 << iota
+	Italic
+	Underline
+	Strikethrough
+	Monospace
+	Reverse
+)
+
+var attrCodes = [...]struct {
+	attr Attr
+	code byte
+}{
+	{Bold, boldCode},
+	{Italic, italicCode},
+	{Underline, underlineCode},
+	{Strikethrough, strikethroughCode},
+	{Monospace, monospaceCode},
+	{Reverse, reverseCode},
+}
+
+// Color is an mIRC palette colour or an RGB colour, the zero Color is the
+// default colour.
+type Color uint32
+
+const (
+	NoColor Color = 0
+
+	paletteFlag Color = 1 << 25
+	rgbFlag     Color = 1 << 24
+)
+
+// The 16 common colours.
+const (
+	White Color = paletteFlag | iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+// palette is the RGB of mIRC colours 0-98.
+var palette = [99]uint32{
+	0xffffff, 0x000000, 0x00007f, 0x009300, 0xff0000, 0x7f0000, 0x9c009c, 0xfc7f00,
+	0xffff00, 0x00fc00, 0x009393, 0x00ffff, 0x0000fc, 0xff00ff, 0x7f7f7f, 0xd2d2d2,
+	0x470000, 0x472100, 0x474700, 0x324700, 0x004700, 0x00472c, 0x004747, 0x002747,
+	0x000047, 0x2e0047, 0x470047, 0x47002a, 0x740000, 0x743a00, 0x747400, 0x517400,
+	0x007400, 0x007449, 0x007474, 0x004074, 0x000074, 0x4b0074, 0x740074, 0x740045,
+	0xb50000, 0xb56300, 0xb5b500, 0x7db500, 0x00b500, 0x00b571, 0x00b5b5, 0x0063b5,
+	0x0000b5, 0x7500b5, 0xb500b5, 0xb5006b, 0xff0000, 0xff8c00, 0xffff00, 0xb2ff00,
+	0x00ff00, 0x00ffa0, 0x00ffff, 0x008cff, 0x0000ff, 0xa500ff, 0xff00ff, 0xff0098,
+	0xff5959, 0xffb459, 0xffff71, 0xcfff60, 0x6fff6f, 0x65ffc9, 0x6dffff, 0x59b4ff,
+	0x5959ff, 0xc459ff, 0xff66ff, 0xff59bc, 0xff9c9c, 0xffd39c, 0xffff9c, 0xe2ff9c,
+	0x9cff9c, 0x9cffdb, 0x9cffff, 0x9cd3ff, 0x9c9cff, 0xdc9cff, 0xff9cff, 0xff94d3,
+	0x000000, 0x131313, 0x282828, 0x363636, 0x4d4d4d, 0x656565, 0x818181, 0x9f9f9f,
+	0xbcbcbc, 0xe2e2e2, 0xffffff,
+}
+
+// PaletteColor returns mIRC colour n, NoColor for 99 and invalid n.
+func PaletteColor(n int) Color {
+	if n < 0 || n >= len(palette) {
+		return NoColor
+	}
+	return paletteFlag | Color(n)
+}
+
+// RGBColor returns the colour of r, g and b.
+func RGBColor(r, g, b uint8) Color {
+	return rgbFlag | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Palette returns the mIRC colour number of c.
+func (c Color) Palette() (n int, ok bool) {
+	if c&paletteFlag == 0 {
+		return 0, false
+	}
+	return int(c &^ paletteFlag), true
+}
+
+// RGB returns the RGB of c, palette colours are converted.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	v := uint32(c &^ rgbFlag)
+	switch {
+	case c&paletteFlag != 0:
+		v = palette[c&^paletteFlag]
+	case c&rgbFlag == 0:
+		return
+	}
+	return uint8(v >> 16), uint8(v >> 8), uint8(v), true
+}
+
+// Style of a span of text.
+type Style struct {
+	Attr Attr
+	Fg   Color
+	Bg   Color
+}
+
+// Span is a run of text in a single style.
+type Span struct {
+	Style
+	Text []byte
+}
+
+// RangeSpans calls f for each non-empty span of formatted text until f
+// returns false. Text of spans are subslices of text.
+func RangeSpans(text []byte, f func(s Span) bool) {
+	var st Style
+	start := 0
+	for i := 0; i < len(text); {
+		c := text[i]
+		if c != colorCode && c != hexColorCode && c != resetCode && attrCode(c) == 0 {
+			i++
+			continue
+		}
+
+		if i > start && !f(Span{st, text[start:i]}) {
+			return
+		}
+		n := 1
+		switch c {
+		case colorCode, hexColorCode:
+			var fg, bg Color
+			var hasBg bool
+			n, fg, bg, hasBg = parseColor(text[i:])
+			switch {
+			case n == 1:
+				st.Fg, st.Bg = NoColor, NoColor
+			case hasBg:
+				st.Fg, st.Bg = fg, bg
+			default:
+				st.Fg = fg
+			}
+		case resetCode:
+			st = Style{}
+		default:
+			st.Attr ^= attrCode(c)
+		}
+		i += n
+		start = i
+	}
+	if start < len(text) {
+		f(Span{st, text[start:]})
+	}
+}
+
+// Spans returns spans of formatted text.
+func Spans(text []byte) (spans []Span) {
+	RangeSpans(text, func(s Span) bool {
+		spans = append(spans, s)
+		return true
+	})
+	return
+}
+
+func attrCode(c byte) Attr {
+	for _, a := range attrCodes {
+		if a.code == c {
+			return a.attr
+		}
+	}
+	return 0
+}
+
+// parseColor parses the colour code at the beginning of b, a bare code
+// (n == 1) resets both colours and bg is kept unless hasBg.
+func parseColor(b []byte) (n int, fg, bg Color, hasBg bool) {
+	n = 1
+	switch b[0] {
+	case colorCode: // ^C[N[N]][,N[N]]
+		d := digits(b[1:], 2, isDigit)
+		if d == 0 {
+			return
+		}
+		fg = paletteOf(b[1 : 1+d])
+		n += d
+		if n+1 < len(b) && b[n] == ',' && isDigit(b[n+1]) {
+			d = digits(b[n+1:], 2, isDigit)
+			bg = paletteOf(b[n+1 : n+1+d])
+			n += 1 + d
+			hasBg = true
+		}
+	case hexColorCode: // ^DRRGGBB[,RRGGBB]
+		if digits(b[1:], 6, isHex) != 6 {
+			return
+		}
+		fg = rgbOf(b[1:7])
+		n = 7
+		if n+6 < len(b) && b[n] == ',' && digits(b[n+1:], 6, isHex) == 6 {
+			bg = rgbOf(b[n+1 : n+7])
+			n += 7
+			hasBg = true
+		}
+	}
+	return
+}
+
+func paletteOf(b []byte) Color {
+	n := 0
+	for _, c := range b {
+		n = n*10 + int(c-'0')
+	}
+	return PaletteColor(n)
+}
+
+func rgbOf(b []byte) Color {
+	v := Color(0)
+	for _, c := range b {
+		switch {
+		case isDigit(c):
+			c -= '0'
+		case c >= 'a':
+			c -= 'a' - 10
+		default:
+			c -= 'A' - 10
+		}
+		v = v<<4 | Color(c)
+	}
+	return rgbFlag | v
+}
+
+// StripFormatting appends text without formatting codes to dst.
+func StripFormatting(dst, text []byte) []byte {
+	RangeSpans(text, func(s Span) bool {
+		dst = append(dst, s.Text...)
+		return true
+	})
+	return dst
+}
+
+// AppendANSI appends formatted text rendered as ANSI terminal escapes to
+// dst, colours are 24-bit.
+func AppendANSI(dst, text []byte) []byte {
+	var last Style
+	RangeSpans(text, func(s Span) bool {
+		if s.Style != last {
+			dst = appendANSIStyle(dst, s.Style)
+			last = s.Style
+		}
+		dst = append(dst, s.Text...)
+		return true
+	})
+	if last != (Style{}) {
+		dst = append(dst, "\x1b[0m"...)
+	}
+	return dst
+}
+
+var ansiAttrs = [...]struct {
+	attr Attr
+	sgr  string
+}{
+	{Bold, ";1"},
+	{Italic, ";3"},
+	{Underline, ";4"},
+	{Reverse, ";7"},
+	{Strikethrough, ";9"},
+}
+
+func appendANSIStyle(dst []byte, st Style) []byte {
+	dst = append(dst, "\x1b[0"...)
+	for _, a := range ansiAttrs {
+		if st.Attr&a.attr != 0 {
+			dst = append(dst, a.sgr...)
+		}
+	}
+	dst = appendANSIColor(dst, ";38;2;", st.Fg)
+	dst = appendANSIColor(dst, ";48;2;", st.Bg)
+	return append(dst, 'm')
+}
+
+func appendANSIColor(dst []byte, sgr string, c Color) []byte {
+	r, g, b, ok := c.RGB()
+	if !ok {
+		return dst
+	}
+	dst = append(dst, sgr...)
+	dst = strconv.AppendUint(dst, uint64(r), 10)
+	dst = append(dst, ';')
+	dst = strconv.AppendUint(dst, uint64(g), 10)
+	dst = append(dst, ';')
+	return strconv.AppendUint(dst, uint64(b), 10)
+}
+
+// AppendHTML appends formatted text rendered as HTML to dst, styled spans
+// are wrapped in <span style="...">. Reverse swaps colours and has no effect
+// on text without colours.
+func AppendHTML(dst, text []byte) []byte {
+	RangeSpans(text, func(s Span) bool {
+		if s.Style == (Style{}) {
+			dst = appendHTMLEscape(dst, s.Text)
+			return true
+		}
+		dst = append(dst, `<span style="`...)
+		dst = appendCSS(dst, s.Style)
+		dst = append(dst, `">`...)
+		dst = appendHTMLEscape(dst, s.Text)
+		dst = append(dst, "</span>"...)
+		return true
+	})
+	return dst
+}
+
+func appendCSS(dst []byte, st Style) []byte {
+	start := len(dst)
+	decl := func(prop, value string) {
+		if len(dst) > start {
+			dst = append(dst, ';')
+		}
+		dst = append(dst, prop...)
+		dst = append(dst, ':')
+		dst = append(dst, value...)
+	}
+	if st.Attr&Bold != 0 {
+		decl("font-weight", "bold")
+	}
+	if st.Attr&Italic != 0 {
+		decl("font-style", "italic")
+	}
+	switch st.Attr & (Underline | Strikethrough) {
+	case Underline:
+		decl("text-decoration", "underline")
+	case Strikethrough:
+		decl("text-decoration", "line-through")
+	case Underline | Strikethrough:
+		decl("text-decoration", "underline line-through")
+	}
+	if st.Attr&Monospace != 0 {
+		decl("font-family", "monospace")
+	}
+	fg, bg := st.Fg, st.Bg
+	if st.Attr&Reverse != 0 {
+		fg, bg = bg, fg
+	}
+	if r, g, b, ok := fg.RGB(); ok {
+		decl("color", cssColor(r, g, b))
+	}
+	if r, g, b, ok := bg.RGB(); ok {
+		decl("background-color", cssColor(r, g, b))
+	}
+	return dst
+}
+
+func cssColor(r, g, b uint8) string {
+	const hex = "0123456789abcdef"
+	return string([]byte{'#',
+		hex[r>>4], hex[r&0xf], hex[g>>4], hex[g&0xf], hex[b>>4], hex[b&0xf]})
+}
+
+func appendHTMLEscape(dst, text []byte) []byte {
+	for _, c := range text {
+		switch c {
+		case '&':
+			dst = append(dst, "&amp;"...)
+		case '<':
+			dst = append(dst, "&lt;"...)
+		case '>':
+			dst = append(dst, "&gt;"...)
+		case '"':
+			dst = append(dst, "&#34;"...)
+		case '\'':
+			dst = append(dst, "&#39;"...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// AppendMarkdown appends formatted text rendered as Markdown to dst.
+// Underline and colours have no Markdown and are dropped.
+func AppendMarkdown(dst, text []byte) []byte {
+	RangeSpans(text, func(s Span) bool {
+		// markers must be next to non-space
+		body := bytes.TrimSpace(s.Text)
+		if len(body) == 0 || s.Attr&(Bold|Italic|Strikethrough|Monospace) == 0 {
+			dst = appendMarkdownEscape(dst, s.Text)
+			return true
+		}
+		lead := bytes.Index(s.Text, body)
+		dst = append(dst, s.Text[:lead]...)
+
+		var open, close []byte
+		for _, m := range [...]struct {
+			attr   Attr
+			marker string
+		}{{Strikethrough, "~~"}, {Bold, "**"}, {Italic, "*"}} {
+			if s.Attr&m.attr != 0 {
+				open = append(open, m.marker...)
+				close = append([]byte(m.marker), close...)
+			}
+		}
+		dst = append(dst, open...)
+		if s.Attr&Monospace != 0 {
+			// code spans can not escape backticks, use double ones
+			fence, end := "`", "`"
+			if bytes.IndexByte(body, '`') >= 0 {
+				fence, end = "`` ", " ``"
+			}
+			dst = append(dst, fence...)
+			dst = append(dst, body...)
+			dst = append(dst, end...)
+		} else {
+			dst = appendMarkdownEscape(dst, body)
+		}
+		dst = append(dst, close...)
+		dst = append(dst, s.Text[lead+len(body):]...)
+		return true
+	})
+	return dst
+}
+
+func appendMarkdownEscape(dst, text []byte) []byte {
+	for _, c := range text {
+		switch c {
+		case '\\', '`', '*', '_', '~', '[', ']', '<', '>', '#', '|':
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// FormatBuilder builds formatted text, each styled text ends with a reset.
+type FormatBuilder struct {
+	buf []byte
+}
+
+// WriteString appends plain s.
+func (b *FormatBuilder) WriteString(s string) {
+	b.buf = append(b.buf, s...)
+}
+
+// Style appends s in style st.
+func (b *FormatBuilder) Style(st Style, s string) {
+	if st == (Style{}) {
+		b.WriteString(s)
+		return
+	}
+	for _, a := range attrCodes {
+		if st.Attr&a.attr != 0 {
+			b.buf = append(b.buf, a.code)
+		}
+	}
+	if st.Fg != NoColor || st.Bg != NoColor {
+		hasBg := b.appendColor(st.Fg, st.Bg)
+		if !hasBg && len(s) != 0 && s[0] == ',' {
+			// keep ',' from being read as bg
+			b.buf = append(b.buf, boldCode, boldCode)
+		}
+	}
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, resetCode)
+}
+
+// appendColor appends a colour code, an RGB colour is not written without
+// a Fg.
+func (b *FormatBuilder) appendColor(fg, bg Color) (hasBg bool) {
+	fn, fok := fg.Palette()
+	bn, bok := bg.Palette()
+	if (fok || fg == NoColor) && (bok || bg == NoColor) {
+		if !fok {
+			fn = 99
+		}
+		b.buf = append(b.buf, colorCode)
+		b.buf = appendTwoDigits(b.buf, fn)
+		if bok {
+			b.buf = append(b.buf, ',')
+			b.buf = appendTwoDigits(b.buf, bn)
+		}
+		return bok
+	}
+
+	r, g, bl, ok := fg.RGB()
+	if !ok {
+		return false
+	}
+	b.buf = append(b.buf, hexColorCode)
+	b.buf = append(b.buf, cssColor(r, g, bl)[1:]...)
+	if r, g, bl, ok = bg.RGB(); ok {
+		b.buf = append(b.buf, ',')
+		b.buf = append(b.buf, cssColor(r, g, bl)[1:]...)
+	}
+	return ok
+}
+
+func appendTwoDigits(dst []byte, n int) []byte {
+	return append(dst, byte('0'+n/10), byte('0'+n%10))
+}
+
+// Bold appends s in bold.
+func (b *FormatBuilder) Bold(s string) {
+	b.Style(Style{Attr: Bold}, s)
+}
+
+// Italic appends s in italics.
+func (b *FormatBuilder) Italic(s string) {
+	b.Style(Style{Attr: Italic}, s)
+}
+
+// Underline appends s underlined.
+func (b *FormatBuilder) Underline(s string) {
+	b.Style(Style{Attr: Underline}, s)
+}
+
+// Color appends s in fg on bg.
+func (b *FormatBuilder) Color(fg, bg Color, s string) {
+	b.Style(Style{Fg: fg, Bg: bg}, s)
+}
+
+// Bytes returns the formatted text.
+func (b *FormatBuilder) Bytes() []byte {
+	return b.buf
+}
+
+func (b *FormatBuilder) String() string {
+	return string(b.buf)
+}
+
+// Len returns length of formatted text.
+func (b *FormatBuilder) Len() int {
+	return len(b.buf)
+}
+
+// Reset empties the builder.
+func (b *FormatBuilder) Reset() {
+	b.buf = b.buf[:0]
+}
@@ -0,0 +1,130 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	text := []byte("a\x02b\x0304,12c\x1d\x03d\x0408ff00,000000e\x0f\x1f\x1e\x11\x16f\x0399g\x03,h")
+	want := []Span{
+		{Style{}, []byte("a")},
+		{Style{Attr: Bold}, []byte("b")},
+		{Style{Attr: Bold, Fg: Red, Bg: LightBlue}, []byte("c")},
+		{Style{Attr: Bold | Italic}, []byte("d")},
+		{Style{Attr: Bold | Italic, Fg: RGBColor(8, 0xff, 0), Bg: RGBColor(0, 0, 0)}, []byte("e")},
+		{Style{Attr: Underline | Strikethrough | Monospace | Reverse}, []byte("f")},
+		{Style{Attr: Underline | Strikethrough | Monospace | Reverse}, []byte("g")},
+		{Style{Attr: Underline | Strikethrough | Monospace | Reverse}, []byte(",h")},
+	}
+	if got := Spans(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+
+	n := testing.AllocsPerRun(100, func() {
+		RangeSpans(text, func(s Span) bool { return true })
+	})
+	if n != 0 {
+		t.Errorf("%v allocs", n)
+	}
+}
+
+func TestSpansColor(t *testing.T) {
+	for _, c := range []struct {
+		text   string
+		fg, bg Color
+		rest   string
+	}{
+		{"\x035,3x", Brown, Green, "x"},
+		{"\x0310,x", Cyan, NoColor, ",x"},
+		{"\x0312345", LightBlue, NoColor, "345"},
+		{"\x04ff00", NoColor, NoColor, "ff00"},
+		{"\x04ABCDEF,12x", RGBColor(0xab, 0xcd, 0xef), NoColor, ",12x"},
+	} {
+		spans := Spans([]byte(c.text))
+		if len(spans) != 1 || spans[0].Fg != c.fg || spans[0].Bg != c.bg || string(spans[0].Text) != c.rest {
+			t.Errorf("%q got %v", c.text, spans)
+		}
+	}
+
+	if n, ok := PaletteColor(52).Palette(); !ok || n != 52 {
+		t.Error(n, ok)
+	}
+	if r, g, b, ok := Orange.RGB(); !ok || r != 0xfc || g != 0x7f || b != 0 {
+		t.Error(r, g, b, ok)
+	}
+	if _, _, _, ok := PaletteColor(99).RGB(); ok {
+		t.Error("99 is not default")
+	}
+}
+
+func TestStripFormatting(t *testing.T) {
+	text := "\x02bold\x02 \x0304,01red\x03, \x04ff0000hex\x0f \x1ditalic"
+	if s := StripFormatting(nil, []byte(text)); string(s) != "bold red, hex italic" {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestAppendANSI(t *testing.T) {
+	text := "a\x02\x0304b\x0f c"
+	want := "a\x1b[0;1;38;2;255;0;0mb\x1b[0m c"
+	if s := AppendANSI(nil, []byte(text)); string(s) != want {
+		t.Errorf("%q", s)
+	}
+	if s := AppendANSI(nil, []byte("\x1fu")); string(s) != "\x1b[0;4mu\x1b[0m" {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestAppendHTML(t *testing.T) {
+	text := "<a>\x02\x1d\x0302,08b&c\x0f \x16\x0304r\x0f \x1f\x1es"
+	want := `&lt;a&gt;<span style="font-weight:bold;font-style:italic;color:#00007f;background-color:#ffff00">b&amp;c</span> ` +
+		`<span style="background-color:#ff0000">r</span> <span style="text-decoration:underline line-through">s</span>`
+	if s := AppendHTML(nil, []byte(text)); string(s) != want {
+		t.Errorf("%s", s)
+	}
+}
+
+func TestAppendMarkdown(t *testing.T) {
+	for _, c := range []struct {
+		text, want string
+	}{
+		{"\x02bold \x02plain", "**bold** plain"},
+		{"\x02\x1d both\x0f", " ***both***"},
+		{"\x1estrike\x1e \x1funder", "~~strike~~ under"},
+		{"\x11a*b\x11 \x11`c\x11", "`a*b` `` `c ``"},
+		{"*_[x]_*", "\\*\\_\\[x\\]\\_\\*"},
+		{"\x0304red", "red"},
+	} {
+		if s := AppendMarkdown(nil, []byte(c.text)); string(s) != c.want {
+			t.Errorf("%q got %q want %q", c.text, s, c.want)
+		}
+	}
+}
+
+func TestFormatBuilder(t *testing.T) {
+	var b FormatBuilder
+	b.WriteString("a ")
+	b.Bold("b")
+	b.Color(Red, NoColor, ",c")
+	b.Color(PaletteColor(52), Black, "d")
+	b.Color(RGBColor(1, 2, 3), Blue, "e")
+	b.Style(Style{Attr: Italic | Underline, Bg: Green}, "f")
+	want := "a \x02b\x0f\x0304\x02\x02,c\x0f\x0352,01d\x0f\x04010203,00007fe\x0f\x1d\x1f\x0399,03f\x0f"
+	if b.String() != want {
+		t.Errorf("%q", b.String())
+	}
+
+	if s := StripFormatting(nil, b.Bytes()); string(s) != "a b,cdef" {
+		t.Errorf("%q", s)
+	}
+	spans := Spans(b.Bytes())
+	if len(spans) != 6 || spans[2].Fg != Red || spans[2].Attr != 0 || spans[5].Bg != Green {
+		t.Error(spans)
+	}
+
+	b.Reset()
+	if b.Len() != 0 {
+		t.Error(b.Len())
+	}
+}
@@ -0,0 +1,227 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ISupport accumulates RPL_ISUPPORT (005) tokens, see
+// http://modern.ircdocs.horse/#rplisupport-005
+//
+// Getters return the RFC1459 defaults for absent tokens.
+type ISupport struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func NewISupport() *ISupport {
+	return &ISupport{tokens: make(map[string]string)}
+}
+
+// Parse adds tokens of a RPL_ISUPPORT msg, other msgs are ignored.
+func (s *ISupport) Parse(msg *Msg) {
+	if string(msg.Cmd()) != RPL_ISUPPORT {
+		return
+	}
+	params := msg.Params()
+	if len(params) < 2 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// skip client nick, the trailing is human readable text
+	for _, p := range params[1:] {
+		if len(p) == 0 {
+			continue
+		}
+		if p[0] == '-' {
+			delete(s.tokens, string(p[1:]))
+			continue
+		}
+
+		name, value := string(p), ""
+		for i, c := range p {
+			if c == '=' {
+				name, value = string(p[:i]), unescapeISupport(p[i+1:])
+				break
+			}
+		}
+		s.tokens[name] = value
+	}
+}
+
+// ServeIRC parses RPL_ISUPPORT msgs.
+func (s *ISupport) ServeIRC(w Writer, msg *Msg) {
+	s.Parse(msg)
+}
+
+// unescapeISupport decodes \xHH escapes.
+func unescapeISupport(b []byte) string {
+	var buf []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+3 < len(b) && b[i+1] == 'x' {
+			if n, err := strconv.ParseUint(string(b[i+2:i+4]), 16, 8); err == nil {
+				buf = append(buf, byte(n))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, b[i])
+	}
+	return string(buf)
+}
+
+// Get returns the value of token name and whether it is advertised.
+func (s *ISupport) Get(name string) (value string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok = s.tokens[name]
+	return
+}
+
+func (s *ISupport) get(name, def string) string {
+	if v, ok := s.Get(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (s *ISupport) getInt(name string, def int) int {
+	if n, err := strconv.Atoi(s.get(name, "")); err == nil {
+		return n
+	}
+	return def
+}
+
+// Prefix returns membership modes and their symbols in the same order,
+// e.g. "ov" and "@+".
+func (s *ISupport) Prefix() (modes, symbols string) {
+	v, ok := s.Get("PREFIX")
+	if !ok {
+		return "ov", "@+"
+	}
+	if len(v) == 0 || v[0] != '(' {
+		return "", ""
+	}
+	n := strings.IndexByte(v, ')')
+	if n < 0 || len(v)-n-1 != n-1 {
+		return "", ""
+	}
+	return v[1:n], v[n+1:]
+}
+
+// PrefixMode returns the mode of membership symbol, e.g. 'o' for '@'.
+func (s *ISupport) PrefixMode(symbol byte) (mode byte, ok bool) {
+	modes, symbols := s.Prefix()
+	if n := strings.IndexByte(symbols, symbol); n >= 0 {
+		return modes[n], true
+	}
+	return
+}
+
+// PrefixSymbol returns the symbol of membership mode, e.g. '@' for 'o'.
+func (s *ISupport) PrefixSymbol(mode byte) (symbol byte, ok bool) {
+	modes, symbols := s.Prefix()
+	if n := strings.IndexByte(modes, mode); n >= 0 {
+		return symbols[n], true
+	}
+	return
+}
+
+// ChanModes returns the CHANMODES groups: A list modes, B modes that always
+// take a parameter, C modes that take a parameter only when set and
+// D modes without parameter.
+func (s *ISupport) ChanModes() (a, b, c, d string) {
+	groups := strings.SplitN(s.get("CHANMODES", "b,k,l,imnpst"), ",", 5)
+	for len(groups) < 4 {
+		groups = append(groups, "")
+	}
+	return groups[0], groups[1], groups[2], groups[3]
+}
+
+// ChanTypes returns channel prefixes.
+func (s *ISupport) ChanTypes() string {
+	v, ok := s.Get("CHANTYPES")
+	if !ok {
+		return string([]byte{Channel, Distributed})
+	}
+	return v
+}
+
+// IsChannel reports whether target is a channel name.
+func (s *ISupport) IsChannel(target string) bool {
+	return len(target) > 0 && strings.IndexByte(s.ChanTypes(), target[0]) >= 0
+}
+
+// CaseMapping returns CASEMAPPING, default rfc1459.
+func (s *ISupport) CaseMapping() string {
+	return s.get("CASEMAPPING", "rfc1459")
+}
+
+// NickLen returns max nick length, default 9.
+func (s *ISupport) NickLen() int {
+	return s.getInt("NICKLEN", 9)
+}
+
+// ChannelLen returns max channel name length, default 200.
+func (s *ISupport) ChannelLen() int {
+	return s.getInt("CHANNELLEN", 200)
+}
+
+// TopicLen returns max topic length, 0 if unlimited.
+func (s *ISupport) TopicLen() int {
+	return s.getInt("TOPICLEN", 0)
+}
+
+// Modes returns max number of modes with parameter in one MODE, default 3,
+// 0 if unlimited.
+func (s *ISupport) Modes() int {
+	v, ok := s.Get("MODES")
+	if !ok {
+		return 3
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// Network returns the network name.
+func (s *ISupport) Network() string {
+	return s.get("NETWORK", "")
+}
+
+// StatusMsg returns the membership symbols that may prefix a channel name
+// in PRIVMSG and NOTICE, e.g. "@+".
+func (s *ISupport) StatusMsg() string {
+	return s.get("STATUSMSG", "")
+}
+
+// TargMax returns max targets of cmd and whether cmd is limited.
+func (s *ISupport) TargMax(cmd string) (n int, ok bool) {
+	for _, f := range strings.Split(s.get("TARGMAX", ""), ",") {
+		i := strings.IndexByte(f, ':')
+		if i < 0 || !strings.EqualFold(f[:i], cmd) {
+			continue
+		}
+		if n, err := strconv.Atoi(f[i+1:]); err == nil {
+			return n, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// MaxList returns max entries of list mode, 0 if unknown.
+func (s *ISupport) MaxList(mode byte) int {
+	for _, f := range strings.Split(s.get("MAXLIST", ""), ",") {
+		i := strings.IndexByte(f, ':')
+		if i < 0 || strings.IndexByte(f[:i], mode) < 0 {
+			continue
+		}
+		n, _ := strconv.Atoi(f[i+1:])
+		return n
+	}
+	return 0
+}
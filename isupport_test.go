@@ -0,0 +1,78 @@
+package irc
+
+import "testing"
+
+func newISupport(t *testing.T, lines ...string) *ISupport {
+	s := NewISupport()
+	for _, l := range lines {
+		msg, err := NewMsg(s2b(l))
+		if err != nil {
+			t.Fatal(l, err)
+		}
+		s.Parse(msg)
+	}
+	return s
+}
+
+func TestISupport(t *testing.T) {
+	s := newISupport(t,
+		":srv 005 nick CHANTYPES=# EXCEPTS INVEX CHANMODES=eIbq,k,flj,CFLMPQScgimnprstz CHANLIMIT=#:120 PREFIX=(ov)@+ MAXLIST=bqeI:100 MODES=4 NETWORK=freenode STATUSMSG=@+ CALLERID=g CASEMAPPING=rfc1459 :are supported by this server",
+		":srv 005 nick CHARSET=ascii NICKLEN=16 CHANNELLEN=50 TOPICLEN=390 DEAF=D FNC TARGMAX=NAMES:1,LIST:1,KICK:1,WHOIS:1,PRIVMSG:4,NOTICE:4,ACCEPT:,MONITOR: EXTBAN=$,ajrxz CLIENTVER=3.0 ETRACE WHOX KNOCK :are supported by this server",
+		":srv 005 nick NETWORK=Example\\x20Net -KNOCK :are supported by this server",
+	)
+
+	if m, sym := s.Prefix(); m != "ov" || sym != "@+" {
+		t.Error(m, sym)
+	}
+	if a, b, c, d := s.ChanModes(); a != "eIbq" || b != "k" || c != "flj" || d != "CFLMPQScgimnprstz" {
+		t.Error(a, b, c, d)
+	}
+	if s.NickLen() != 16 || s.ChannelLen() != 50 || s.TopicLen() != 390 || s.Modes() != 4 {
+		t.Error(s.NickLen(), s.ChannelLen(), s.TopicLen(), s.Modes())
+	}
+	if s.Network() != "Example Net" || s.StatusMsg() != "@+" || s.CaseMapping() != "rfc1459" {
+		t.Error(s.Network(), s.StatusMsg(), s.CaseMapping())
+	}
+	if n, ok := s.TargMax(PRIVMSG); !ok || n != 4 {
+		t.Error(n, ok)
+	}
+	if _, ok := s.TargMax("ACCEPT"); ok {
+		t.Error("ACCEPT limited")
+	}
+	if n := s.MaxList('q'); n != 100 {
+		t.Error(n)
+	}
+	if _, ok := s.Get("KNOCK"); ok {
+		t.Error("KNOCK not negated")
+	}
+	if v, ok := s.Get("EXCEPTS"); !ok || v != "" {
+		t.Error(v, ok)
+	}
+	if !s.IsChannel("#foo") || s.IsChannel("&foo") {
+		t.Error(s.ChanTypes())
+	}
+}
+
+func TestISupportDefaults(t *testing.T) {
+	s := newISupport(t, ":srv 005 nick PREFIX=(qaohv)~&@%+ :are supported by this server")
+	if m, ok := s.PrefixMode('%'); !ok || m != ModeHalfOperator {
+		t.Error(string(m), ok)
+	}
+	if sym, ok := s.PrefixSymbol(ModeOwner); !ok || sym != Owner {
+		t.Error(string(sym), ok)
+	}
+	if _, ok := s.PrefixMode('!'); ok {
+		t.Error("'!' is prefix")
+	}
+	if a, b, c, d := s.ChanModes(); a != "b" || b != "k" || c != "l" || d != "imnpst" {
+		t.Error(a, b, c, d)
+	}
+	if s.NickLen() != 9 || s.Modes() != 3 || s.CaseMapping() != "rfc1459" || !s.IsChannel("&foo") {
+		t.Error(s.NickLen(), s.Modes(), s.CaseMapping())
+	}
+
+	s = newISupport(t, ":srv 005 nick PREFIX= :are supported by this server")
+	if m, sym := s.Prefix(); m != "" || sym != "" {
+		t.Error(m, sym)
+	}
+}
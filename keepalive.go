@@ -0,0 +1,137 @@
+package irc
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TimeoutError is returned when nothing is received within
+// Keepalive.Timeout, it is a net.Error whose Timeout is true.
+type TimeoutError struct {
+	Idle time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return "irc: nothing received for " + e.Idle.String()
+}
+
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// Keepalive sends PING every Interval, measures lag by the matching PONG
+// and closes the connection if nothing is received for Timeout. Every
+// received msg must be passed to ServeIRC, Client does so if it is set as
+// Client.Keepalive.
+type Keepalive struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Clock    Clock
+
+	mu       sync.Mutex
+	last     time.Time // last receive
+	token    string    // of PING not answered yet
+	sent     time.Time
+	seq      int
+	lag      time.Duration
+	measured bool
+	err      error
+}
+
+// NewKeepalive returns a keepalive with interval and timeout.
+func NewKeepalive(interval, timeout time.Duration) *Keepalive {
+	return &Keepalive{Interval: interval, Timeout: timeout, Clock: SystemClock}
+}
+
+// ServeIRC records traffic and measures lag by PONG of the last PING.
+func (k *Keepalive) ServeIRC(w Writer, msg *Msg) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.last = k.Clock.Now()
+	if string(msg.Cmd()) != PONG || k.token == "" {
+		return
+	}
+	token := msg.Trailing()
+	if token == nil {
+		if p := msg.Params(); len(p) > 0 {
+			token = p[len(p)-1]
+		}
+	}
+	if string(token) == k.token {
+		k.lag, k.measured = k.last.Sub(k.sent), true
+		k.token = ""
+	}
+}
+
+// Lag returns the round-trip time of the last answered PING, or the time
+// since the pending PING if longer. ok is false before any measurement.
+func (k *Keepalive) Lag() (lag time.Duration, ok bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	lag = k.lag
+	if k.token != "" {
+		if d := k.Clock.Now().Sub(k.sent); d > lag {
+			return d, true
+		}
+	}
+	return lag, k.measured
+}
+
+// Err returns *TimeoutError once the connection was closed by timeout.
+func (k *Keepalive) Err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+// Run pings by w until ctx is done or nothing is received for Timeout, then
+// it closes c and returns *TimeoutError.
+func (k *Keepalive) Run(ctx context.Context, w Writer, c io.Closer) error {
+	k.mu.Lock()
+	now := k.Clock.Now()
+	k.last = now
+	next := now.Add(k.Interval)
+	k.mu.Unlock()
+
+	for {
+		k.mu.Lock()
+		now := k.Clock.Now()
+		deadline := k.last.Add(k.Timeout)
+		if !now.Before(deadline) {
+			k.err = &TimeoutError{now.Sub(k.last)}
+			err := k.err
+			k.mu.Unlock()
+			c.Close()
+			return err
+		}
+		var token string
+		if !now.Before(next) {
+			k.seq++
+			token = strconv.Itoa(k.seq)
+			k.token, k.sent = token, now
+			next = now.Add(k.Interval)
+		}
+		wait := next
+		if deadline.Before(wait) {
+			wait = deadline
+		}
+		k.mu.Unlock()
+
+		if token != "" {
+			msg, err := Ping(token)
+			if err != nil {
+				return err
+			}
+			if _, err = w.Encode(msg); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-k.Clock.After(wait.Sub(k.Clock.Now())):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	clock := newFakeClock()
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot"})
+	c.Keepalive = NewKeepalive(time.Minute, 3*time.Minute)
+	c.Keepalive.Clock = clock
+	pongs := make(chan struct{})
+	c.Handler = HandlerFunc(func(w Writer, msg *Msg) {
+		if string(msg.Cmd()) == PONG {
+			pongs <- struct{}{}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kdone := make(chan error, 1)
+	go func() { kdone <- c.Keepalive.Run(ctx, c, conn) }()
+	done := make(chan error, 1)
+	go func() { done <- c.Run() }()
+
+	if _, ok := c.Keepalive.Lag(); ok {
+		t.Error("lag before PING")
+	}
+	clock.Wait(1)
+	clock.Advance(time.Minute)
+	srv.expect("PING :1")
+	clock.Advance(150 * time.Millisecond)
+	srv.send(":srv PONG srv :wrong")
+	<-pongs
+	srv.send(":srv PONG srv :1")
+	<-pongs
+	if lag, ok := c.Keepalive.Lag(); !ok || lag != 150*time.Millisecond {
+		t.Error(lag, ok)
+	}
+
+	// pending PING longer than the last lag
+	clock.Wait(1)
+	clock.Advance(time.Minute - 150*time.Millisecond)
+	srv.expect("PING :2")
+	clock.Advance(time.Second)
+	if lag, _ := c.Keepalive.Lag(); lag != time.Second {
+		t.Error(lag)
+	}
+
+	// timeout counts from the last PONG at 1m150ms
+	clock.Wait(1)
+	clock.Advance(time.Minute)
+	srv.expect("PING :3")
+	clock.Wait(1)
+	clock.Advance(time.Minute)
+
+	var te *TimeoutError
+	err := <-kdone
+	if !errors.As(err, &te) || te.Idle != 3*time.Minute+850*time.Millisecond {
+		t.Fatal(err)
+	}
+	err = <-done
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() || err != c.Keepalive.Err() {
+		t.Error(err)
+	}
+}
+
+func TestKeepaliveStop(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeepalive(time.Minute, 2*time.Minute)
+	k.Clock = clock
+	_, conn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- k.Run(ctx, NewEncoder(conn), conn) }()
+	clock.Wait(1)
+	cancel()
+	if err := <-done; err != context.Canceled || k.Err() != nil {
+		t.Error(err, k.Err())
+	}
+}
@@ -0,0 +1,162 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// ModeChange is one change of a MODE msg.
+type ModeChange struct {
+	Add  bool
+	Mode byte
+	Arg  string
+}
+
+func (c ModeChange) String() string {
+	s := "-" + string(c.Mode)
+	if c.Add {
+		s = "+" + string(c.Mode)
+	}
+	if c.Arg != "" {
+		s += " " + c.Arg
+	}
+	return s
+}
+
+// modeTakesArg reports whether mode takes an argument by PREFIX and
+// CHANMODES of s.
+func modeTakesArg(s *ISupport, mode byte, add bool) bool {
+	modes, _ := s.Prefix()
+	if strings.IndexByte(modes, mode) >= 0 {
+		return true
+	}
+	a, b, c, _ := s.ChanModes()
+	switch {
+	case strings.IndexByte(a, mode) >= 0, strings.IndexByte(b, mode) >= 0:
+		return true
+	case strings.IndexByte(c, mode) >= 0:
+		return add
+	}
+	return false
+}
+
+// isListMode reports whether mode is a CHANMODES type A mode.
+func isListMode(s *ISupport, mode byte) bool {
+	a, _, _, _ := s.ChanModes()
+	return strings.IndexByte(a, mode) >= 0
+}
+
+// ParseModes parses channel mode string like "+ov-k" and its args into
+// changes. A list mode without arg (a list query like "+b") gets an empty
+// Arg.
+func ParseModes(s *ISupport, modes []byte, args [][]byte) (changes []ModeChange, err error) {
+	add := true
+	for _, m := range modes {
+		switch m {
+		case '+':
+			add = true
+			continue
+		case '-':
+			add = false
+			continue
+		}
+
+		c := ModeChange{Add: add, Mode: m}
+		if modeTakesArg(s, m, add) {
+			if len(args) > 0 {
+				c.Arg, args = string(args[0]), args[1:]
+			} else if !isListMode(s, m) {
+				return changes, errors.New("irc: mode " + c.String() + " needs an argument")
+			}
+		}
+		changes = append(changes, c)
+	}
+	return
+}
+
+// ParseUserModes parses user mode string like "+iw-o", user modes take no
+// argument.
+func ParseUserModes(modes []byte) (changes []ModeChange) {
+	add := true
+	for _, m := range modes {
+		switch m {
+		case '+':
+			add = true
+		case '-':
+			add = false
+		default:
+			changes = append(changes, ModeChange{Add: add, Mode: m})
+		}
+	}
+	return
+}
+
+// ParseModeMsg parses a MODE or RPL_CHANNELMODEIS msg into target and
+// changes, a trailing is used as the last argument. Modes of a target which
+// is no channel are user modes.
+func ParseModeMsg(s *ISupport, msg *Msg) (target string, changes []ModeChange, err error) {
+	params := msg.Params()
+	if string(msg.Cmd()) == RPL_CHANNELMODEIS && len(params) > 0 {
+		// skip client nick
+		params = params[1:]
+	}
+	if t := msg.Trailing(); t != nil {
+		params = append(params[:len(params):len(params)], t)
+	}
+	if len(params) < 2 {
+		return "", nil, errors.New("irc: mode msg needs target and modes")
+	}
+	target = string(params[0])
+	if !s.IsChannel(target) {
+		return target, ParseUserModes(params[1]), nil
+	}
+	changes, err = ParseModes(s, params[1], params[2:])
+	return
+}
+
+// ModeMsgs packs changes of target into MODE msgs, each msg holds at most
+// ISUPPORT MODES changes with argument.
+func ModeMsgs(s *ISupport, target string, changes []ModeChange) (msgs []*Msg) {
+	limit := s.Modes()
+
+	var (
+		modes []byte
+		args  [][]byte
+		sign  byte
+		n     int
+	)
+	flush := func() {
+		if len(modes) == 0 {
+			return
+		}
+		msg := new(Msg)
+		msg.SetCmd([]byte(MODE))
+		msg.SetParams(append([][]byte{[]byte(target), modes}, args...)...)
+		msgs = append(msgs, msg)
+		modes, args, sign, n = nil, nil, 0, 0
+	}
+
+	for _, c := range changes {
+		withArg := c.Arg != "" && modeTakesArg(s, c.Mode, c.Add)
+		// 16 params: target, modes and 14 args
+		if withArg && ((limit > 0 && n == limit) || len(args) == 14) {
+			flush()
+		}
+
+		cs := byte('-')
+		if c.Add {
+			cs = '+'
+		}
+		if cs != sign {
+			modes = append(modes, cs)
+			sign = cs
+		}
+		modes = append(modes, c.Mode)
+		if withArg {
+			args = append(args, []byte(c.Arg))
+			n++
+		}
+	}
+	flush()
+	return
+}
@@ -0,0 +1,133 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseModeMsg(t *testing.T) {
+	s := NewISupport()
+	msg, _ := NewMsg(s2b(":op!u@h MODE #chan +ov-k+l alice bob secret 10"))
+	target, changes, err := ParseModeMsg(s, msg)
+	if err != nil || target != "#chan" {
+		t.Fatal(target, err)
+	}
+	want := []ModeChange{
+		{true, ModeOperator, "alice"},
+		{true, ModeVoice, "bob"},
+		{false, ModeKey, "secret"},
+		{true, ModeLimit, "10"},
+	}
+	if len(changes) != len(want) {
+		t.Fatal(changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Error(i, changes[i], want[i])
+		}
+	}
+}
+
+func TestParseModesISupport(t *testing.T) {
+	s := newISupport(t, ":srv 005 nick PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,fl,imnpst :are supported")
+	changes, err := ParseModes(s, s2b("-l+qb-m"), [][]byte{s2b("owner"), s2b("*!*@bad")})
+	if err != nil || len(changes) != 4 {
+		t.Fatal(changes, err)
+	}
+	if c := changes[0]; c.Add || c.Mode != ModeLimit || c.Arg != "" {
+		t.Error(c)
+	}
+	if c := changes[1]; !c.Add || c.Mode != ModeOwner || c.Arg != "owner" {
+		t.Error(c)
+	}
+	if c := changes[2]; c.Mode != 'b' || c.Arg != "*!*@bad" {
+		t.Error(c)
+	}
+	if c := changes[3]; c.Add || c.Mode != ModeModerated {
+		t.Error(c)
+	}
+
+	// list query
+	changes, err = ParseModes(s, s2b("+b"), nil)
+	if err != nil || len(changes) != 1 || changes[0].Arg != "" {
+		t.Error(changes, err)
+	}
+
+	if _, err = ParseModes(s, s2b("+o"), nil); err == nil {
+		t.Error("+o without arg")
+	}
+}
+
+func TestParseModeMsgTrailing(t *testing.T) {
+	s := NewISupport()
+	msg, _ := NewMsg(s2b(":srv 324 me #chan +kl key :20"))
+	target, changes, err := ParseModeMsg(s, msg)
+	if err != nil || target != "#chan" || len(changes) != 2 || changes[1].Arg != "20" {
+		t.Error(target, changes, err)
+	}
+}
+
+func TestParseUserModeMsg(t *testing.T) {
+	s := NewISupport()
+	for _, raw := range []string{":srv MODE alice +o", ":alice MODE alice :+iwo"} {
+		msg, _ := NewMsg(s2b(raw))
+		target, changes, err := ParseModeMsg(s, msg)
+		if err != nil || target != "alice" || len(changes) == 0 {
+			t.Fatal(raw, changes, err)
+		}
+		if c := changes[len(changes)-1]; !c.Add || c.Mode != ModeOperator || c.Arg != "" {
+			t.Error(raw, c)
+		}
+	}
+
+	changes := ParseUserModes(s2b("+i-wx"))
+	want := []ModeChange{{true, 'i', ""}, {false, 'w', ""}, {false, 'x', ""}}
+	if len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] || changes[2] != want[2] {
+		t.Error(changes)
+	}
+}
+
+func TestModeMsgs(t *testing.T) {
+	s := NewISupport()
+	changes := []ModeChange{
+		{true, ModeOperator, "a"},
+		{true, ModeOperator, "b"},
+		{false, ModeVoice, "c"},
+		{true, ModeModerated, ""},
+		{true, ModeVoice, "d"},
+		{false, ModeTopic, ""},
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	for _, msg := range ModeMsgs(s, "#chan", changes) {
+		enc.Encode(msg)
+	}
+	want := "MODE #chan +oo-v+m a b c\r\n" +
+		"MODE #chan +v-t d\r\n"
+	if buf.String() != want {
+		t.Errorf("%q", buf.String())
+	}
+
+	s = newISupport(t, ":srv 005 nick MODES :are supported")
+	if msgs := ModeMsgs(s, "#chan", changes); len(msgs) != 1 {
+		t.Error(len(msgs))
+	}
+}
+
+func TestModeRoundTrip(t *testing.T) {
+	s := NewISupport()
+	changes := []ModeChange{{true, ModeKey, "pw"}, {false, ModeLimit, ""}, {true, 'b', "*!*@x"}}
+	msgs := ModeMsgs(s, "#c", changes)
+	if len(msgs) != 1 {
+		t.Fatal(msgs)
+	}
+	_, got, err := ParseModeMsg(s, msgs[0])
+	if err != nil || len(got) != 3 {
+		t.Fatal(got, err)
+	}
+	for i := range got {
+		if got[i] != changes[i] {
+			t.Error(got[i], changes[i])
+		}
+	}
+}
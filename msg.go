@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 )
 
 const (
 	prefixSymbol byte = 0x3a // Prefix Symbol
+	tagsSymbol   byte = 0x40 // Tags
 	userSymbol   byte = 0x21 // Username
 	hostSymbol   byte = 0x40 // Hostname
 	space        byte = 0x20 // Sepector
@@ -15,6 +17,7 @@ const (
 
 type Msg struct {
 	Data     []byte
+	tags     []byte
 	prefix   []byte
 	name     []byte
 	user     []byte
@@ -27,6 +30,12 @@ type Msg struct {
 	paramsParsed bool
 	index        int
 	paramsCount  int
+
+	tagBuf   []byte // owned buffer for tags set by SetTag
+	own      []byte // owned buffer for Detach
+	detached bool
+
+	received time.Time
 }
 
 // Prefix
@@ -131,6 +140,28 @@ func (m *Msg) SetTrailing(p []byte) {
 	m.paramsParsed = true
 }
 
+// argCount returns the number of params, counting the trailing if present.
+func (m *Msg) argCount() int {
+	n := len(m.Params())
+	if m.trailing != nil {
+		n++
+	}
+	return n
+}
+
+// arg returns the i-th argument whether in params or trailing, empty if
+// missing.
+func (m *Msg) arg(i int) string {
+	params := m.Params()
+	if i < len(params) {
+		return string(params[i])
+	}
+	if i == len(params) {
+		return string(m.trailing)
+	}
+	return ""
+}
+
 func (m *Msg) Cmd() []byte {
 	return m.cmd
 }
@@ -155,8 +186,33 @@ func (m *Msg) PeekCmd() (err error) {
 	var n int
 	b := m.Data
 
-	if m.hasPrefix() {
+	if len(b) == 0 {
+		err = errors.New("empty message")
+		return
+	}
+
+	if b[0] == tagsSymbol {
 		n = bytes.IndexByte(b, space)
+		if n < 0 {
+			err = errors.New("no command")
+			return
+		}
+		if n == 1 {
+			err = errors.New("tags is empty")
+			return
+		}
+
+		m.tags = b[1:n]
+		m.index = n + 1
+		b = b[n+1:]
+	}
+
+	if len(b) != 0 && b[0] == prefixSymbol {
+		n = bytes.IndexByte(b, space)
+		if n < 0 {
+			err = errors.New("no command")
+			return
+		}
 		if n == 1 {
 			err = errors.New("prefix is empty")
 			return
@@ -164,7 +220,7 @@ func (m *Msg) PeekCmd() (err error) {
 		}
 
 		m.prefix = b[1:n]
-		m.index = n
+		m.index += n
 		b = b[n+1:]
 
 	}
@@ -173,7 +229,7 @@ func (m *Msg) PeekCmd() (err error) {
 	if n < 0 {
 		// no params
 		m.cmd = b
-		m.index = m.index + len(b) + 1
+		m.index = len(m.Data)
 	} else {
 		m.cmd = b[:n]
 		m.index += n + 1
@@ -220,33 +276,28 @@ func (m *Msg) parseParams() (err error) {
 	var n int
 	m.paramsCount = 0
 	b := m.Data[m.index:]
-	// find trailing
-	n = bytes.IndexByte(b, prefixSymbol)
-	if n < 0 {
-		// No trailing
-		n = len(b) - 1
-	} else {
-		m.trailing = b[n+1:]
-		b = b[:n]
-	}
-	for {
-		if len(b) == 0 {
-			break
-		}
-		n = bytes.IndexByte(b, space)
-		if n == 0 {
+	for len(b) != 0 {
+		if b[0] == space {
 			b = b[1:]
 			continue
 		}
+		// trailing starts with ':' or takes the rest after the last param
+		if b[0] == prefixSymbol || m.paramsCount == len(m.params) {
+			if b[0] == prefixSymbol {
+				b = b[1:]
+			}
+			m.trailing = b
+			break
+		}
+		n = bytes.IndexByte(b, space)
 		if n < 0 {
-			m.params[m.paramsCount] = b[:]
+			m.params[m.paramsCount] = b
 			m.paramsCount += 1
 			break
-		} else {
-			m.params[m.paramsCount] = b[:n]
-			b = b[n:]
 		}
+		m.params[m.paramsCount] = b[:n]
 		m.paramsCount += 1
+		b = b[n:]
 	}
 
 	m.paramsParsed = true
@@ -260,10 +311,6 @@ func (m *Msg) ParseAll() (err error) {
 	return
 }
 
-func (m *Msg) hasPrefix() bool {
-	return m.Data[0] == prefixSymbol
-}
-
 func (m *Msg) String() string {
 	return fmt.Sprintf("CMD:%s, Params:%s, Prefix=%s Trailing=%s",
 		m.cmd, m.params, m.prefix, m.trailing)
@@ -271,6 +318,8 @@ func (m *Msg) String() string {
 
 func (m *Msg) Reset() {
 	m.Data = nil
+	m.tags = nil
+	m.tagBuf = m.tagBuf[:0]
 	m.prefix = nil
 	m.cmd = nil
 	m.name = nil
@@ -279,6 +328,8 @@ func (m *Msg) Reset() {
 	m.trailing = nil
 	m.paramsParsed = false
 	m.prefixParsed = false
+	m.detached = false
+	m.received = time.Time{}
 
 	for i := 0; i < m.paramsCount; i++ {
 		m.params[i] = nil
@@ -244,6 +244,18 @@ var messageTests = [...]*struct {
 		true,
 		false,
 	},
+	{
+		":irc.vives.lan 005 test TARGMAX=PRIVMSG:4,NOTICE:4 :are supported by this server",
+		&Msg{cmd: s2b("005"),
+			trailing: s2b("are supported by this server"),
+			name:     s2b("irc.vives.lan"),
+			user:     nil,
+			host:     nil,
+			params:   [16][]byte{s2b("test"), s2b("TARGMAX=PRIVMSG:4,NOTICE:4")},
+		},
+		false,
+		true,
+	},
 }
 
 func TestInvaildMsg(t *testing.T) {
@@ -0,0 +1,132 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Middleware wraps a Handler.
+type Middleware func(Handler) Handler
+
+// Mux dispatches msgs to handlers registered by command or numeric.
+//
+// Patterns are exact commands like PRIVMSG or RPL_WELCOME, or end with '*'
+// to match every command with the prefix, e.g. "4*" for all 4xx numerics
+// and "*" for catch-all. The exact command wins, then the longest prefix.
+// Commands are matched case-insensitively.
+type Mux struct {
+	mu         sync.RWMutex
+	exact      map[string]*muxEntry
+	wildcards  []*muxEntry // longest prefix first
+	middleware []Middleware
+}
+
+type muxEntry struct {
+	pattern string
+	h       Handler
+	chain   Handler // h wrapped in middleware
+}
+
+func NewMux() *Mux {
+	return &Mux{exact: make(map[string]*muxEntry)}
+}
+
+// Handle registers h for pattern, replacing any previous handler.
+func (m *Mux) Handle(pattern string, h Handler) {
+	if pattern == "" {
+		panic("irc: empty pattern")
+	}
+	if h == nil {
+		panic("irc: nil handler")
+	}
+	pattern = strings.ToUpper(pattern)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := &muxEntry{pattern: pattern, h: h, chain: m.wrap(h)}
+	if pattern[len(pattern)-1] != '*' {
+		m.exact[pattern] = e
+		return
+	}
+
+	e.pattern = pattern[:len(pattern)-1]
+	for i, w := range m.wildcards {
+		if w.pattern == e.pattern {
+			m.wildcards[i] = e
+			return
+		}
+	}
+	m.wildcards = append(m.wildcards, e)
+	sort.SliceStable(m.wildcards, func(i, j int) bool {
+		return len(m.wildcards[i].pattern) > len(m.wildcards[j].pattern)
+	})
+}
+
+// HandleFunc registers f for pattern.
+func (m *Mux) HandleFunc(pattern string, f func(w Writer, msg *Msg)) {
+	m.Handle(pattern, HandlerFunc(f))
+}
+
+// Use appends middleware to the chain, the first one is the outermost.
+func (m *Mux) Use(mw ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.middleware = append(m.middleware, mw...)
+	for _, e := range m.exact {
+		e.chain = m.wrap(e.h)
+	}
+	for _, e := range m.wildcards {
+		e.chain = m.wrap(e.h)
+	}
+}
+
+func (m *Mux) wrap(h Handler) Handler {
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	return h
+}
+
+// Handler returns the handler for cmd, nil if none matches.
+func (m *Mux) Handler(cmd []byte) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if e := m.match(cmd); e != nil {
+		return e.chain
+	}
+	return nil
+}
+
+func (m *Mux) match(cmd []byte) *muxEntry {
+	var upper [32]byte
+	if len(cmd) <= len(upper) {
+		for i, c := range cmd {
+			if 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			upper[i] = c
+		}
+		cmd = upper[:len(cmd)]
+	}
+
+	if e, ok := m.exact[string(cmd)]; ok {
+		return e
+	}
+	for _, e := range m.wildcards {
+		if len(cmd) >= len(e.pattern) && string(cmd[:len(e.pattern)]) == e.pattern {
+			return e
+		}
+	}
+	return nil
+}
+
+// ServeIRC dispatches msg to the matching handler.
+func (m *Mux) ServeIRC(w Writer, msg *Msg) {
+	if h := m.Handler(msg.Cmd()); h != nil {
+		h.ServeIRC(w, msg)
+	}
+}
@@ -0,0 +1,108 @@
+package irc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	var got string
+	mux := NewMux()
+	mux.HandleFunc(PRIVMSG, func(w Writer, msg *Msg) { got = "privmsg" })
+	mux.HandleFunc(RPL_WELCOME, func(w Writer, msg *Msg) { got = "welcome" })
+	mux.HandleFunc("4*", func(w Writer, msg *Msg) { got = "error" })
+	mux.HandleFunc("43*", func(w Writer, msg *Msg) { got = "nick error" })
+	mux.HandleFunc("*", func(w Writer, msg *Msg) { got = "any" })
+
+	for raw, want := range map[string]string{
+		":n!u@h PRIVMSG #chan :hi":           "privmsg",
+		":n!u@h privmsg #chan :hi":           "privmsg",
+		":srv 001 bot :Welcome":              "welcome",
+		":srv 401 bot nick :No such nick":    "error",
+		":srv 433 * bot :Nickname is in use": "nick error",
+		":n!u@h JOIN #chan":                  "any",
+	} {
+		got = ""
+		msg, _ := NewMsg(s2b(raw))
+		mux.ServeIRC(nil, msg)
+		if got != want {
+			t.Errorf("%s: want %s got %s", raw, want, got)
+		}
+	}
+}
+
+func TestMuxNoMatch(t *testing.T) {
+	mux := NewMux()
+	mux.HandleFunc(PRIVMSG, func(w Writer, msg *Msg) { t.Error(msg) })
+	msg, _ := NewMsg(s2b("NOTICE #chan :hi"))
+	mux.ServeIRC(nil, msg)
+	if mux.Handler(s2b(JOIN)) != nil {
+		t.Error("JOIN has handler")
+	}
+}
+
+func TestMuxMiddleware(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w Writer, msg *Msg) {
+				order = append(order, name)
+				next.ServeIRC(w, msg)
+			})
+		}
+	}
+
+	mux := NewMux()
+	mux.HandleFunc(PING, func(w Writer, msg *Msg) {
+		order = append(order, "handler")
+		pong := new(Msg)
+		pong.SetCmd(s2b(PONG))
+		pong.SetParams(msg.Params()...)
+		w.Encode(pong)
+	})
+	mux.Use(mw("a"), mw("b"))
+
+	buf := bytes.NewBuffer(nil)
+	msg, _ := NewMsg(s2b("PING abc"))
+	mux.ServeIRC(NewEncoder(buf), msg)
+
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "handler" {
+		t.Error(order)
+	}
+	if buf.String() != "PONG abc\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+}
+
+func TestMuxAlloc(t *testing.T) {
+	mux := NewMux()
+	mux.HandleFunc(PRIVMSG, func(w Writer, msg *Msg) {})
+	mux.HandleFunc("*", func(w Writer, msg *Msg) {})
+	enc := NewEncoder(ioutil.Discard)
+	msgs := []*Msg{}
+	for _, raw := range []string{":n PRIVMSG #a :b", ":n privmsg #a :b", ":n JOIN #a"} {
+		msg, _ := NewMsg(s2b(raw))
+		msgs = append(msgs, msg)
+	}
+	n := testing.AllocsPerRun(100, func() {
+		for _, msg := range msgs {
+			mux.ServeIRC(enc, msg)
+		}
+	})
+	if n != 0 {
+		t.Error("allocs", n)
+	}
+}
+
+func BenchmarkMux(b *testing.B) {
+	mux := NewMux()
+	for _, cmd := range []string{PRIVMSG, NOTICE, JOIN, PART, RPL_WELCOME, "4*"} {
+		mux.HandleFunc(cmd, func(w Writer, msg *Msg) {})
+	}
+	msg, _ := NewMsg(s2b(":n!u@h PRIVMSG #chan :hello"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeIRC(nil, msg)
+	}
+}
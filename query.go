@@ -0,0 +1,532 @@
+package irc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Query describes a command whose reply is a burst of numerics, e.g. WHOIS
+// is answered by RPL_WHOISUSER, RPL_WHOISSERVER ... RPL_ENDOFWHOIS.
+//
+// Servers answer commands in order, so replies go to the oldest pending
+// query expecting them. If labeled-response is enabled the command is sent
+// with a label and replies are matched by it instead.
+type Query struct {
+	Msg *Msg
+
+	// Target must be a middle param after the client nick of replies,
+	// compared under CASEMAPPING, empty matches any reply.
+	Target string
+
+	// UntargetedReplies applies Target to End and Errors only, e.g. WHO
+	// replies for a mask carry the matched nick instead. Such replies go
+	// to the oldest query expecting them.
+	UntargetedReplies bool
+
+	Replies []string // numerics of the reply
+	End     string   // numeric ending the reply
+	Errors  []string // numerics failing the query
+}
+
+// ReplyError is an error numeric received for a query.
+type ReplyError struct {
+	Code string
+	Text string
+}
+
+func (e *ReplyError) Error() string {
+	return "irc: " + e.Code + " " + e.Text
+}
+
+type pendingQuery struct {
+	q     *Query
+	label string
+	batch string // labeled-response batch ref
+	msgs  []*Msg
+	err   error
+	done  chan struct{}
+
+	// finished by an error numeric, the End that usually follows is
+	// still consumed
+	draining bool
+
+	abandoned bool // ctx of Do done
+}
+
+func (p *pendingQuery) finish(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+	close(p.done)
+}
+
+func (p *pendingQuery) fail(msg *Msg) bool {
+	cmd := string(msg.Cmd())
+	for _, e := range p.q.Errors {
+		if e == cmd {
+			p.err = &ReplyError{cmd, string(msg.Trailing())}
+			return true
+		}
+	}
+	return false
+}
+
+// Do sends q.Msg and collects replies until q.End, an error numeric of
+// q.Errors or the end of the labeled-response batch. Replies are passed to
+// Handler as well.
+//
+// A query stays pending after ctx is done, so its late replies are not
+// taken by later queries. It is dropped once a later query of the same End,
+// or a later labeled query, is answered as the server answers in order. A
+// server which never answers a kind of query grows the pending queries
+// until the connection ends.
+func (c *Client) Do(ctx context.Context, q *Query) ([]*Msg, error) {
+	p := &pendingQuery{q: q, done: make(chan struct{})}
+	msg := q.Msg
+	if c.caps != nil && c.caps.Enabled("labeled-response") {
+		msg = q.Msg.Clone()
+		c.mu.Lock()
+		c.label++
+		p.label = strconv.Itoa(c.label)
+		c.mu.Unlock()
+		msg.SetTag([]byte("label"), []byte(p.label))
+	}
+
+	c.mu.Lock()
+	if c.queryErr != nil {
+		err := c.queryErr
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.queries = append(c.queries, p)
+	c.mu.Unlock()
+
+	if _, err := c.enc.Encode(msg); err != nil {
+		c.mu.Lock()
+		c.removeQuery(p)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case <-p.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return p.msgs, p.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		p.abandoned = true
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// removeQuery removes p from pending queries, c.mu must be held.
+func (c *Client) removeQuery(p *pendingQuery) {
+	for i, q := range c.queries {
+		if q == p {
+			c.queries = append(c.queries[:i], c.queries[i+1:]...)
+			return
+		}
+	}
+}
+
+// dropAbandoned removes abandoned queries sent before p which would have
+// been answered before p, c.mu must be held.
+func (c *Client) dropAbandoned(p *pendingQuery) {
+	queries := c.queries[:0]
+	before := true
+	for _, q := range c.queries {
+		if q == p {
+			before = false
+		}
+		if before && q.abandoned && (q.label != "") == (p.label != "") &&
+			(q.label != "" || q.q.End == p.q.End) {
+			continue
+		}
+		queries = append(queries, q)
+	}
+	for i := len(queries); i < len(c.queries); i++ {
+		c.queries[i] = nil
+	}
+	c.queries = queries
+}
+
+// failQueries fails pending and later queries with err.
+func (c *Client) failQueries(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queryErr = err
+	for _, p := range c.queries {
+		if !p.draining {
+			p.finish(err)
+		}
+	}
+	c.queries = nil
+}
+
+// routeQuery passes msg to the pending query it replies to.
+func (c *Client) routeQuery(msg *Msg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queries) == 0 {
+		return
+	}
+
+	if label, ok := msg.Tag([]byte("label")); ok {
+		c.routeLabel(string(label), msg)
+		return
+	}
+	if ref, ok := msg.Tag([]byte("batch")); ok {
+		for _, p := range c.queries {
+			if p.batch != "" && p.batch == string(ref) {
+				p.msgs = append(p.msgs, msg.Clone())
+				p.fail(msg)
+				return
+			}
+		}
+	}
+	if string(msg.Cmd()) == BATCH {
+		if ref := msg.arg(0); len(ref) > 1 && ref[0] == '-' {
+			for _, p := range c.queries {
+				if p.batch != "" && p.batch == ref[1:] {
+					c.dropAbandoned(p)
+					c.removeQuery(p)
+					p.finish(nil)
+					return
+				}
+			}
+		}
+		return
+	}
+
+	cmd := string(msg.Cmd())
+	cm := c.isupport.CaseMap()
+	for i := 0; i < len(c.queries); i++ {
+		p := c.queries[i]
+		if p.label != "" || !p.expects(cmd) || !p.targets(cm, msg) {
+			continue
+		}
+		if p.draining {
+			c.removeQuery(p)
+			if cmd == p.q.End {
+				return
+			}
+			i--
+			continue
+		}
+
+		p.msgs = append(p.msgs, msg.Clone())
+		c.dropAbandoned(p)
+		switch {
+		case cmd == p.q.End:
+			c.removeQuery(p)
+			p.finish(nil)
+		case p.fail(msg):
+			p.draining = p.q.End != ""
+			if !p.draining {
+				c.removeQuery(p)
+			}
+			p.finish(nil)
+		}
+		return
+	}
+}
+
+// routeLabel handles msg labeled with label, c.mu must be held.
+func (c *Client) routeLabel(label string, msg *Msg) {
+	for _, p := range c.queries {
+		if p.label != label {
+			continue
+		}
+		if string(msg.Cmd()) == BATCH {
+			if ref := msg.arg(0); len(ref) > 1 && ref[0] == '+' {
+				p.batch = ref[1:]
+				return
+			}
+		}
+		// a single reply
+		if string(msg.Cmd()) != ACK {
+			p.msgs = append(p.msgs, msg.Clone())
+			p.fail(msg)
+		}
+		c.dropAbandoned(p)
+		c.removeQuery(p)
+		p.finish(nil)
+		return
+	}
+}
+
+func (p *pendingQuery) expects(cmd string) bool {
+	if cmd == p.q.End {
+		return true
+	}
+	for _, r := range p.q.Replies {
+		if r == cmd {
+			return true
+		}
+	}
+	for _, e := range p.q.Errors {
+		if e == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// targets reports whether msg replies to the target of p.
+func (p *pendingQuery) targets(cm CaseMapping, msg *Msg) bool {
+	cmd := string(msg.Cmd())
+	if p.q.UntargetedReplies && cmd != p.q.End {
+		for _, r := range p.q.Replies {
+			if r == cmd {
+				return true
+			}
+		}
+	}
+	return replyTo(cm, msg, p.q.Target)
+}
+
+// replyTo reports whether target is a middle param after the client nick of
+// msg, error numerics without such params reply to any target.
+func replyTo(cm CaseMapping, msg *Msg, target string) bool {
+	params := msg.Params()
+	if target == "" || len(params) < 2 {
+		return true
+	}
+	for _, p := range params[1:] {
+		if cm.EqualString(string(p), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WhoisInfo is the aggregated reply to WHOIS.
+type WhoisInfo struct {
+	User     WhoisUser
+	Server   WhoisServer
+	Idle     WhoisIdle
+	Channels []string // with membership prefix
+	Operator bool
+	Away     string // away message, empty if not away
+}
+
+// Whois queries nick and collects the replies until RPL_ENDOFWHOIS.
+func (c *Client) Whois(ctx context.Context, nick string) (info *WhoisInfo, err error) {
+	msg, err := Whois(nick)
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:    msg,
+		Target: nick,
+		Replies: []string{RPL_WHOISUSER, RPL_WHOISSERVER, RPL_WHOISOPERATOR,
+			RPL_WHOISIDLE, RPL_WHOISCHANNELS, RPL_AWAY},
+		End:    RPL_ENDOFWHOIS,
+		Errors: []string{ERR_NOSUCHNICK, ERR_NOSUCHSERVER, ERR_NONICKNAMEGIVEN},
+	})
+	if err != nil {
+		return
+	}
+
+	info = new(WhoisInfo)
+	for _, m := range msgs {
+		switch string(m.Cmd()) {
+		case RPL_WHOISUSER:
+			info.User, err = ParseWhoisUser(m)
+		case RPL_WHOISSERVER:
+			info.Server, err = ParseWhoisServer(m)
+		case RPL_WHOISIDLE:
+			info.Idle, err = ParseWhoisIdle(m)
+		case RPL_WHOISCHANNELS:
+			var wc WhoisChannels
+			wc, err = ParseWhoisChannels(m)
+			info.Channels = append(info.Channels, wc.Channels...)
+		case RPL_WHOISOPERATOR:
+			info.Operator = true
+		case RPL_AWAY:
+			info.Away = string(m.Trailing())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Who queries mask and collects RPL_WHOREPLY until RPL_ENDOFWHO.
+func (c *Client) Who(ctx context.Context, mask string) (replies []WhoReply, err error) {
+	msg, err := Who(mask)
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Target:  mask,
+		Replies: []string{RPL_WHOREPLY},
+		End:     RPL_ENDOFWHO,
+		Errors:  []string{ERR_NOSUCHSERVER},
+
+		UntargetedReplies: true,
+	})
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if string(m.Cmd()) != RPL_WHOREPLY {
+			continue
+		}
+		r, err := ParseWhoReply(m)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, r)
+	}
+	return
+}
+
+// Names queries members of channel and merges RPL_NAMREPLY until
+// RPL_ENDOFNAMES.
+func (c *Client) Names(ctx context.Context, channel string) (names NamesReply, err error) {
+	msg, err := Names(channel)
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Target:  channel,
+		Replies: []string{RPL_NAMREPLY},
+		End:     RPL_ENDOFNAMES,
+		Errors:  []string{ERR_TOOMANYMATCHES, ERR_NOSUCHSERVER},
+	})
+	if err != nil {
+		return
+	}
+	names.Channel = channel
+	for _, m := range msgs {
+		if string(m.Cmd()) != RPL_NAMREPLY {
+			continue
+		}
+		r, err := ParseNamesReply(c.isupport, m)
+		if err != nil {
+			return names, err
+		}
+		names.ChanType, names.Channel = r.ChanType, r.Channel
+		names.Members = append(names.Members, r.Members...)
+	}
+	return
+}
+
+// List queries channels, all if empty, and collects RPL_LIST until
+// RPL_LISTEND.
+func (c *Client) List(ctx context.Context, channels ...string) (replies []ListReply, err error) {
+	msg, err := List(channels...)
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Replies: []string{RPL_LISTSTART, RPL_LIST},
+		End:     RPL_LISTEND,
+		Errors:  []string{ERR_TOOMANYMATCHES, ERR_NOSUCHSERVER},
+	})
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if string(m.Cmd()) != RPL_LIST {
+			continue
+		}
+		r, err := ParseListReply(m)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, r)
+	}
+	return
+}
+
+// Motd queries the message of the day and returns its lines.
+func (c *Client) Motd(ctx context.Context) (lines []string, err error) {
+	msg, err := Motd("")
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Replies: []string{RPL_MOTDSTART, RPL_MOTD},
+		End:     RPL_ENDOFMOTD,
+		Errors:  []string{ERR_NOMOTD, ERR_NOSUCHSERVER},
+	})
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if string(m.Cmd()) == RPL_MOTD {
+			lines = append(lines, strings.TrimPrefix(string(m.Trailing()), "- "))
+		}
+	}
+	return
+}
+
+// BanList queries bans of channel and collects RPL_BANLIST until
+// RPL_ENDOFBANLIST.
+func (c *Client) BanList(ctx context.Context, channel string) (bans []BanEntry, err error) {
+	msg, err := Mode(channel, []ModeChange{{Add: true, Mode: 'b'}})
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Target:  channel,
+		Replies: []string{RPL_BANLIST},
+		End:     RPL_ENDOFBANLIST,
+		Errors:  []string{ERR_NOSUCHCHANNEL, ERR_NOTONCHANNEL, ERR_CHANOPRIVSNEEDED},
+	})
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if string(m.Cmd()) != RPL_BANLIST {
+			continue
+		}
+		b, err := ParseBanEntry(m)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+	return
+}
+
+// Whowas queries former users of nick, at most count if count > 0, and
+// collects RPL_WHOWASUSER until RPL_ENDOFWHOWAS.
+func (c *Client) Whowas(ctx context.Context, nick string, count int) (users []WhoisUser, err error) {
+	msg, err := Whowas(nick, count)
+	if err != nil {
+		return
+	}
+	msgs, err := c.Do(ctx, &Query{
+		Msg:     msg,
+		Target:  nick,
+		Replies: []string{RPL_WHOWASUSER, RPL_WHOISSERVER},
+		End:     RPL_ENDOFWHOWAS,
+		Errors:  []string{ERR_WASNOSUCHNICK, ERR_NONICKNAMEGIVEN},
+	})
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		if string(m.Cmd()) != RPL_WHOWASUSER {
+			continue
+		}
+		u, err := ParseWhowasUser(m)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return
+}
@@ -0,0 +1,349 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func runClient(t *testing.T, cfg *Config) (*fakeServer, *Client, chan error) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, cfg)
+	done := make(chan error, 1)
+	go func() { done <- c.Run() }()
+	return srv, c, done
+}
+
+func TestClientWhois(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+	ctx := context.Background()
+
+	type result struct {
+		info *WhoisInfo
+		err  error
+	}
+	bob, alice := make(chan result), make(chan result)
+	go func() {
+		info, err := c.Whois(ctx, "bob")
+		bob <- result{info, err}
+	}()
+	srv.expect("WHOIS bob")
+	go func() {
+		info, err := c.Whois(ctx, "alice")
+		alice <- result{info, err}
+	}()
+	srv.expect("WHOIS alice")
+
+	srv.send(
+		":srv 311 me Bob ~b host * :Bob Smith",
+		":srv 312 me Bob irc.example :Example",
+		":x!u@h PRIVMSG me :hi",
+		":srv 319 me Bob :@#go #irc",
+		":srv 301 me Bob :gone fishing",
+		":srv 318 me Bob :End of /WHOIS list.",
+		":srv 311 me alice ~a host * :Alice",
+		":srv 313 me alice :is an IRC operator",
+		":srv 318 me alice :End of /WHOIS list.",
+	)
+
+	r := <-bob
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.info.User.RealName != "Bob Smith" || r.info.Server.Server != "irc.example" ||
+		!reflect.DeepEqual(r.info.Channels, []string{"@#go", "#irc"}) || r.info.Away != "gone fishing" {
+		t.Errorf("bob = %+v", r.info)
+	}
+	r = <-alice
+	if r.err != nil || r.info.User.User != "~a" || !r.info.Operator {
+		t.Errorf("alice = %+v, %v", r.info, r.err)
+	}
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientQueryError(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+	ctx := context.Background()
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Whois(ctx, "nobody")
+		errc <- err
+	}()
+	srv.expect("WHOIS nobody")
+	srv.send(":srv 401 me nobody :No such nick/channel")
+	err := <-errc
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != ERR_NOSUCHNICK {
+		t.Fatal(err)
+	}
+
+	// the trailing RPL_ENDOFWHOIS does not end the next query
+	type result struct {
+		info *WhoisInfo
+		err  error
+	}
+	res := make(chan result)
+	go func() {
+		info, err := c.Whois(ctx, "nobody")
+		res <- result{info, err}
+	}()
+	srv.expect("WHOIS nobody")
+	srv.send(
+		":srv 318 me nobody :End of /WHOIS list.",
+		":srv 311 me nobody ~n host * :Nobody",
+		":srv 318 me nobody :End of /WHOIS list.",
+	)
+	if r := <-res; r.err != nil || r.info.User.RealName != "Nobody" {
+		t.Errorf("%+v, %v", r.info, r.err)
+	}
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientWhoMask(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+	ctx := context.Background()
+
+	type result struct {
+		replies []WhoReply
+		err     error
+	}
+	mask, nick := make(chan result), make(chan result)
+	go func() {
+		r, err := c.Who(ctx, "*.example")
+		mask <- result{r, err}
+	}()
+	srv.expect("WHO *.example")
+	go func() {
+		r, err := c.Who(ctx, "carol")
+		nick <- result{r, err}
+	}()
+	srv.expect("WHO carol")
+
+	srv.send(
+		":srv 352 me * ~a a.example srv alice H :0 Alice",
+		":srv 352 me #go ~b b.example srv bob G@ :2 Bob",
+		":srv 315 me *.example :End of /WHO list.",
+		":srv 352 me * ~c host srv carol H :0 Carol",
+		":srv 315 me carol :End of /WHO list.",
+	)
+
+	r := <-mask
+	if r.err != nil || len(r.replies) != 2 || r.replies[0].Nick != "alice" || r.replies[1].Nick != "bob" {
+		t.Errorf("%+v, %v", r.replies, r.err)
+	}
+	r = <-nick
+	if r.err != nil || len(r.replies) != 1 || r.replies[0].RealName != "Carol" {
+		t.Errorf("%+v, %v", r.replies, r.err)
+	}
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientNamesMotd(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+	ctx := context.Background()
+	srv.send(":srv 005 me PREFIX=(qov)~@+ :are supported by this server")
+
+	namesc := make(chan NamesReply)
+	go func() {
+		names, err := c.Names(ctx, "#Go")
+		if err != nil {
+			t.Error(err)
+		}
+		namesc <- names
+	}()
+	srv.expect("NAMES #Go")
+	srv.send(
+		":srv 353 me = #go :~alice @bob",
+		":srv 353 me = #go :+carol",
+		":srv 366 me #go :End of /NAMES list.",
+	)
+	names := <-namesc
+	want := []NamesMember{{"~", "alice", "", ""}, {"@", "bob", "", ""}, {"+", "carol", "", ""}}
+	if names.Channel != "#go" || !reflect.DeepEqual(names.Members, want) {
+		t.Errorf("names = %+v", names)
+	}
+
+	motdc := make(chan []string)
+	go func() {
+		lines, err := c.Motd(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		motdc <- lines
+	}()
+	srv.expect("MOTD")
+	srv.send(
+		":srv 375 me :- srv Message of the day -",
+		":srv 372 me :- Hello",
+		":srv 372 me :- World",
+		":srv 376 me :End of /MOTD command.",
+	)
+	if lines := <-motdc; !reflect.DeepEqual(lines, []string{"Hello", "World"}) {
+		t.Errorf("motd = %q", lines)
+	}
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientLabeledQuery(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "me", Caps: []string{"labeled-response", "batch"}})
+	go func() {
+		srv.expect("CAP LS 302")
+		srv.expect("NICK me")
+		srv.expect("USER me 0 * me")
+		srv.send(":srv CAP * LS :batch labeled-response")
+		srv.expect("CAP REQ :batch labeled-response")
+		srv.send(":srv CAP me ACK :batch labeled-response")
+		srv.expect("CAP END")
+		srv.send(":srv 001 me :Welcome")
+	}()
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Run() }()
+	ctx := context.Background()
+
+	whoc := make(chan []WhoReply)
+	go func() {
+		replies, err := c.Who(ctx, "#go")
+		if err != nil {
+			t.Error(err)
+		}
+		whoc <- replies
+	}()
+	srv.expect("@label=1 WHO #go")
+	srv.send(
+		"@label=1 :srv BATCH +w labeled-response",
+		"@batch=w :srv 352 me #go ~a host srv alice H :0 Alice",
+		// unlabeled reply of another command is not taken
+		":srv 352 me #go ~x host srv other H :0 Other",
+		"@batch=w :srv 352 me #go ~b host srv bob G@ :1 Bob",
+		"@batch=w :srv 315 me #go :End of /WHO list.",
+		":srv BATCH -w",
+	)
+	replies := <-whoc
+	if len(replies) != 2 || replies[0].Nick != "alice" || replies[1].Prefix != "@" {
+		t.Errorf("who = %+v", replies)
+	}
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Whois(ctx, "nobody")
+		errc <- err
+	}()
+	srv.expect("@label=2 WHOIS nobody")
+	srv.send("@label=2 :srv 401 me nobody :No such nick/channel")
+	var re *ReplyError
+	if err := <-errc; !errors.As(err, &re) || re.Code != ERR_NOSUCHNICK {
+		t.Error(err)
+	}
+
+	// batch refs sent as trailing
+	go func() {
+		replies, err := c.Who(ctx, "#go")
+		if err != nil {
+			t.Error(err)
+		}
+		whoc <- replies
+	}()
+	srv.expect("@label=3 WHO #go")
+	srv.send(
+		"@label=3 :srv BATCH :+t",
+		"@batch=t :srv 352 me #go ~a host srv alice H :0 Alice",
+		"@batch=t :srv 315 me #go :End of /WHO list.",
+		":srv BATCH :-t",
+	)
+	if replies := <-whoc; len(replies) != 1 {
+		t.Errorf("who = %+v", replies)
+	}
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientQueryAbandoned(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errc := make(chan error)
+	go func() {
+		_, err := c.Whois(ctx, "bob")
+		errc <- err
+	}()
+	srv.expect("WHOIS bob")
+	<-errc
+	go func() {
+		_, err := c.Motd(ctx)
+		errc <- err
+	}()
+	srv.expect("MOTD")
+	<-errc
+
+	go func() {
+		_, err := c.Whois(context.Background(), "alice")
+		errc <- err
+	}()
+	srv.expect("WHOIS alice")
+	srv.send(
+		":srv 311 me alice ~a host * :Alice",
+		":srv 318 me alice :End of /WHOIS list.",
+	)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// the unanswered WHOIS is dropped, MOTD may still be answered
+	c.mu.Lock()
+	if len(c.queries) != 1 || c.queries[0].q.End != RPL_ENDOFMOTD {
+		t.Error(len(c.queries))
+	}
+	c.mu.Unlock()
+
+	srv.conn.Close()
+	<-done
+}
+
+func TestClientQueryCancel(t *testing.T) {
+	srv, c, done := runClient(t, &Config{Nick: "me"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errc := make(chan error)
+	go func() {
+		_, err := c.List(ctx)
+		errc <- err
+	}()
+	srv.expect("LIST")
+	if err := <-errc; err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	go func() {
+		_, err := c.Who(context.Background(), "#go")
+		errc <- err
+	}()
+	srv.expect("WHO #go")
+	srv.conn.Close()
+	if err := <-errc; err != io.EOF {
+		t.Error(err)
+	}
+	<-done
+
+	if _, err := c.Motd(context.Background()); err != io.EOF {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,308 @@
+package irc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed numeric replies. Parse functions check the numeric and the number
+// of params, the client nick which starts every numeric is skipped.
+
+// replyArgs returns params and trailing of numeric msg without the client
+// nick, at least min of them.
+func replyArgs(msg *Msg, numeric string, min int) ([]string, error) {
+	if string(msg.Cmd()) != numeric {
+		return nil, errors.New("irc: " + string(msg.Cmd()) + " is not " + numeric)
+	}
+	var args []string
+	for _, p := range msg.Params() {
+		args = append(args, string(p))
+	}
+	if t := msg.Trailing(); t != nil {
+		args = append(args, string(t))
+	}
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	if len(args) < min {
+		return nil, errors.New("irc: " + numeric + " needs " + strconv.Itoa(min) +
+			" params after nick, got " + strconv.Itoa(len(args)))
+	}
+	return args, nil
+}
+
+func parseUnix(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("irc: invalid timestamp " + strconv.Quote(s))
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// WhoisUser is RPL_WHOISUSER: nick user host * :realname
+type WhoisUser struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+}
+
+// ParseWhoisUser parses a RPL_WHOISUSER.
+func ParseWhoisUser(msg *Msg) (r WhoisUser, err error) {
+	args, err := replyArgs(msg, RPL_WHOISUSER, 5)
+	if err != nil {
+		return
+	}
+	return WhoisUser{args[0], args[1], args[2], args[4]}, nil
+}
+
+// ParseWhowasUser parses a RPL_WHOWASUSER which has the layout of
+// RPL_WHOISUSER.
+func ParseWhowasUser(msg *Msg) (r WhoisUser, err error) {
+	args, err := replyArgs(msg, RPL_WHOWASUSER, 5)
+	if err != nil {
+		return
+	}
+	return WhoisUser{args[0], args[1], args[2], args[4]}, nil
+}
+
+// WhoisServer is RPL_WHOISSERVER: nick server :info
+type WhoisServer struct {
+	Nick   string
+	Server string
+	Info   string
+}
+
+// ParseWhoisServer parses a RPL_WHOISSERVER.
+func ParseWhoisServer(msg *Msg) (r WhoisServer, err error) {
+	args, err := replyArgs(msg, RPL_WHOISSERVER, 3)
+	if err != nil {
+		return
+	}
+	return WhoisServer{args[0], args[1], args[2]}, nil
+}
+
+// WhoisIdle is RPL_WHOISIDLE: nick seconds [signon] :seconds idle
+type WhoisIdle struct {
+	Nick   string
+	Idle   time.Duration
+	SignOn time.Time // zero if not sent
+}
+
+// ParseWhoisIdle parses a RPL_WHOISIDLE.
+func ParseWhoisIdle(msg *Msg) (r WhoisIdle, err error) {
+	args, err := replyArgs(msg, RPL_WHOISIDLE, 3)
+	if err != nil {
+		return
+	}
+	sec, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return r, errors.New("irc: invalid idle seconds " + strconv.Quote(args[1]))
+	}
+	r = WhoisIdle{Nick: args[0], Idle: time.Duration(sec) * time.Second}
+	if len(args) > 3 {
+		r.SignOn, err = parseUnix(args[2])
+	}
+	return
+}
+
+// WhoisChannels is RPL_WHOISCHANNELS: nick :{[prefix]channel}
+type WhoisChannels struct {
+	Nick string
+	// Channels with membership prefix, e.g. "@#go".
+	Channels []string
+}
+
+// ParseWhoisChannels parses a RPL_WHOISCHANNELS.
+func ParseWhoisChannels(msg *Msg) (r WhoisChannels, err error) {
+	args, err := replyArgs(msg, RPL_WHOISCHANNELS, 2)
+	if err != nil {
+		return
+	}
+	return WhoisChannels{args[0], strings.Fields(args[1])}, nil
+}
+
+// WhoReply is RPL_WHOREPLY:
+// channel user host server nick flags :hopcount realname
+type WhoReply struct {
+	Channel  string
+	User     string
+	Host     string
+	Server   string
+	Nick     string
+	Away     bool   // flags has G instead of H
+	Oper     bool   // flags has *
+	Prefix   string // membership symbols in flags
+	Hops     int
+	RealName string
+}
+
+// ParseWhoReply parses a RPL_WHOREPLY.
+func ParseWhoReply(msg *Msg) (r WhoReply, err error) {
+	args, err := replyArgs(msg, RPL_WHOREPLY, 7)
+	if err != nil {
+		return
+	}
+	r = WhoReply{
+		Channel: args[0],
+		User:    args[1],
+		Host:    args[2],
+		Server:  args[3],
+		Nick:    args[4],
+	}
+	flags := args[5]
+	if flags != "" && (flags[0] == 'H' || flags[0] == 'G') {
+		r.Away = flags[0] == 'G'
+		flags = flags[1:]
+	}
+	if flags != "" && flags[0] == '*' {
+		r.Oper = true
+		flags = flags[1:]
+	}
+	r.Prefix = flags
+
+	hops, real := args[6], ""
+	if n := strings.IndexByte(hops, space); n >= 0 {
+		hops, real = hops[:n], hops[n+1:]
+	}
+	if r.Hops, err = strconv.Atoi(hops); err != nil {
+		return r, errors.New("irc: invalid hopcount " + strconv.Quote(hops))
+	}
+	r.RealName = real
+	return
+}
+
+// NamesMember is a member of RPL_NAMREPLY.
+type NamesMember struct {
+	Prefix string // membership symbols, e.g. "@+"
+	Nick   string
+	User   string // with userhost-in-names
+	Host   string
+}
+
+// NamesReply is RPL_NAMREPLY: type channel :{[prefix]nick}
+type NamesReply struct {
+	ChanType byte // '=' public, '*' private, '@' secret
+	Channel  string
+	Members  []NamesMember
+}
+
+// ParseNamesReply parses a RPL_NAMREPLY with membership symbols of s.
+func ParseNamesReply(s *ISupport, msg *Msg) (r NamesReply, err error) {
+	args, err := replyArgs(msg, RPL_NAMREPLY, 3)
+	if err != nil {
+		return
+	}
+	if len(args[0]) != 1 {
+		return r, errors.New("irc: invalid channel type " + strconv.Quote(args[0]))
+	}
+	r = NamesReply{ChanType: args[0][0], Channel: args[1]}
+
+	_, symbols := s.Prefix()
+	for _, m := range strings.Fields(args[2]) {
+		n := 0
+		for n < len(m) && strings.IndexByte(symbols, m[n]) >= 0 {
+			n++
+		}
+		nick, user, host := splitMask(m[n:])
+		r.Members = append(r.Members, NamesMember{m[:n], nick, user, host})
+	}
+	return
+}
+
+// ListReply is RPL_LIST: channel visible :topic
+type ListReply struct {
+	Channel string
+	Users   int
+	Topic   string
+}
+
+// ParseListReply parses a RPL_LIST.
+func ParseListReply(msg *Msg) (r ListReply, err error) {
+	args, err := replyArgs(msg, RPL_LIST, 2)
+	if err != nil {
+		return
+	}
+	r = ListReply{Channel: args[0]}
+	if r.Users, err = strconv.Atoi(args[1]); err != nil {
+		return r, errors.New("irc: invalid user count " + strconv.Quote(args[1]))
+	}
+	if len(args) > 2 {
+		r.Topic = args[2]
+	}
+	return
+}
+
+// TopicReply is RPL_TOPIC: channel :topic
+type TopicReply struct {
+	Channel string
+	Topic   string
+}
+
+// ParseTopicReply parses a RPL_TOPIC.
+func ParseTopicReply(msg *Msg) (r TopicReply, err error) {
+	args, err := replyArgs(msg, RPL_TOPIC, 2)
+	if err != nil {
+		return
+	}
+	return TopicReply{args[0], args[1]}, nil
+}
+
+// TopicWhoTime is RPL_TOPICWHOTIME: channel setter time
+type TopicWhoTime struct {
+	Channel string
+	SetBy   string
+	SetAt   time.Time
+}
+
+// ParseTopicWhoTime parses a RPL_TOPICWHOTIME.
+func ParseTopicWhoTime(msg *Msg) (r TopicWhoTime, err error) {
+	args, err := replyArgs(msg, RPL_TOPICWHOTIME, 3)
+	if err != nil {
+		return
+	}
+	r = TopicWhoTime{Channel: args[0], SetBy: args[1]}
+	r.SetAt, err = parseUnix(args[2])
+	return
+}
+
+// BanEntry is RPL_BANLIST: channel mask [setter time]
+type BanEntry struct {
+	Channel string
+	Mask    string
+	SetBy   string    // empty if not sent
+	SetAt   time.Time // zero if not sent
+}
+
+// ParseBanEntry parses a RPL_BANLIST.
+func ParseBanEntry(msg *Msg) (r BanEntry, err error) {
+	args, err := replyArgs(msg, RPL_BANLIST, 2)
+	if err != nil {
+		return
+	}
+	r = BanEntry{Channel: args[0], Mask: args[1]}
+	if len(args) > 3 {
+		r.SetBy = args[2]
+		r.SetAt, err = parseUnix(args[3])
+	}
+	return
+}
+
+// IsonReply is RPL_ISON: :{nick}
+type IsonReply struct {
+	Nicks []string
+}
+
+// ParseIsonReply parses a RPL_ISON.
+func ParseIsonReply(msg *Msg) (r IsonReply, err error) {
+	args, err := replyArgs(msg, RPL_ISON, 0)
+	if err != nil {
+		return
+	}
+	for _, a := range args {
+		r.Nicks = append(r.Nicks, strings.Fields(a)...)
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWhoisReplies(t *testing.T) {
+	u, err := ParseWhoisUser(newTestMsg(":srv 311 me bob ~b host.example * :Bob Smith"))
+	if err != nil || u != (WhoisUser{"bob", "~b", "host.example", "Bob Smith"}) {
+		t.Errorf("WhoisUser = %+v, %v", u, err)
+	}
+	s, err := ParseWhoisServer(newTestMsg(":srv 312 me bob irc.example :Example server"))
+	if err != nil || s != (WhoisServer{"bob", "irc.example", "Example server"}) {
+		t.Errorf("WhoisServer = %+v, %v", s, err)
+	}
+	i, err := ParseWhoisIdle(newTestMsg(":srv 317 me bob 90 1700000000 :seconds idle, signon time"))
+	if err != nil || i.Idle != 90*time.Second || !i.SignOn.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("WhoisIdle = %+v, %v", i, err)
+	}
+	i, err = ParseWhoisIdle(newTestMsg(":srv 317 me bob 5 :seconds idle"))
+	if err != nil || i.Idle != 5*time.Second || !i.SignOn.IsZero() {
+		t.Errorf("WhoisIdle = %+v, %v", i, err)
+	}
+	c, err := ParseWhoisChannels(newTestMsg(":srv 319 me bob :@#go +#irc #test"))
+	if err != nil || !reflect.DeepEqual(c.Channels, []string{"@#go", "+#irc", "#test"}) {
+		t.Errorf("WhoisChannels = %+v, %v", c, err)
+	}
+}
+
+func TestParseWhoReply(t *testing.T) {
+	r, err := ParseWhoReply(newTestMsg(":srv 352 me #go ~b host srv.example bob G*@ :3 Bob Smith"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WhoReply{"#go", "~b", "host", "srv.example", "bob", true, true, "@", 3, "Bob Smith"}
+	if r != want {
+		t.Errorf("WhoReply = %+v, want %+v", r, want)
+	}
+	if _, err = ParseWhoReply(newTestMsg(":srv 352 me #go ~b host srv bob H :x Bob")); err == nil {
+		t.Error("invalid hopcount accepted")
+	}
+}
+
+func TestParseNamesReply(t *testing.T) {
+	s := newISupport(t, ":srv 005 me PREFIX=(qov)~@+ :are supported")
+	r, err := ParseNamesReply(s, newTestMsg(":srv 353 me @ #go :~@alice +bob carol!c@host"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NamesReply{'@', "#go", []NamesMember{
+		{"~@", "alice", "", ""},
+		{"+", "bob", "", ""},
+		{"", "carol", "c", "host"},
+	}}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("NamesReply = %+v, want %+v", r, want)
+	}
+}
+
+func TestParseChannelReplies(t *testing.T) {
+	l, err := ParseListReply(newTestMsg(":srv 322 me #go 42 :Go talk"))
+	if err != nil || l != (ListReply{"#go", 42, "Go talk"}) {
+		t.Errorf("ListReply = %+v, %v", l, err)
+	}
+	tr, err := ParseTopicReply(newTestMsg(":srv 332 me #go :Go talk"))
+	if err != nil || tr != (TopicReply{"#go", "Go talk"}) {
+		t.Errorf("TopicReply = %+v, %v", tr, err)
+	}
+	tw, err := ParseTopicWhoTime(newTestMsg(":srv 333 me #go alice!a@host 1700000000"))
+	if err != nil || tw.SetBy != "alice!a@host" || !tw.SetAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("TopicWhoTime = %+v, %v", tw, err)
+	}
+	b, err := ParseBanEntry(newTestMsg(":srv 367 me #go *!*@spam alice 1700000000"))
+	if err != nil || b.Mask != "*!*@spam" || b.SetBy != "alice" || b.SetAt.Unix() != 1700000000 {
+		t.Errorf("BanEntry = %+v, %v", b, err)
+	}
+	b, err = ParseBanEntry(newTestMsg(":srv 367 me #go *!*@spam"))
+	if err != nil || b.Mask != "*!*@spam" || b.SetBy != "" || !b.SetAt.IsZero() {
+		t.Errorf("BanEntry = %+v, %v", b, err)
+	}
+	is, err := ParseIsonReply(newTestMsg(":srv 303 me :alice bob"))
+	if err != nil || !reflect.DeepEqual(is.Nicks, []string{"alice", "bob"}) {
+		t.Errorf("IsonReply = %+v, %v", is, err)
+	}
+}
+
+func TestParseReplyErrors(t *testing.T) {
+	_, err := ParseWhoisUser(newTestMsg(":srv 311 me bob ~b host"))
+	if err == nil || !strings.Contains(err.Error(), "311 needs 5 params after nick, got 3") {
+		t.Errorf("err = %v", err)
+	}
+	_, err = ParseWhoisUser(newTestMsg(":srv 312 me bob srv :info"))
+	if err == nil || !strings.Contains(err.Error(), "312 is not 311") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = ParseTopicWhoTime(newTestMsg(":srv 333 me #go alice never")); err == nil {
+		t.Error("invalid timestamp accepted")
+	}
+	if _, err = ParseListReply(newTestMsg(":srv 322 me #go many :topic")); err == nil {
+		t.Error("invalid user count accepted")
+	}
+}
@@ -0,0 +1,358 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxAuthenticate is the max length of base64 payload in one AUTHENTICATE.
+const maxAuthenticate = 400
+
+// SASLMechanism is a SASL mechanism used by AUTHENTICATE.
+type SASLMechanism interface {
+	// Name of mechanism, e.g. PLAIN.
+	Name() string
+	// Next returns the response to the decoded server challenge,
+	// the first challenge is empty.
+	Next(challenge []byte) (response []byte, err error)
+}
+
+// SASLCompleter is implemented by mechanisms which verify the server,
+// RPL_SASLSUCCESS fails with *SASLError unless Complete reports true.
+type SASLCompleter interface {
+	Complete() bool
+}
+
+// SASLError is returned when the server rejects authentication.
+type SASLError struct {
+	Numeric string
+	Message string
+	Mechs   []string // mechs server supports from RPL_SASLMECHS
+}
+
+func (e *SASLError) Error() string {
+	return "irc: SASL " + e.Numeric + " " + e.Message
+}
+
+// SASL authenticates with Mech by AUTHENTICATE, see
+// http://ircv3.net/specs/extensions/sasl-3.1.html
+type SASL struct {
+	Mech SASLMechanism
+
+	// Done is called once when authentication finished.
+	Done func(w Writer, err error)
+
+	mu        sync.Mutex
+	challenge []byte
+	mechs     []string
+	account   string
+	finished  bool
+	err       error
+}
+
+// Start sends AUTHENTICATE with the name of mechanism, a mechanism with a
+// Reset method is reset first as it may be reused by reconnects.
+func (s *SASL) Start(w Writer) error {
+	if r, ok := s.Mech.(interface{ Reset() }); ok {
+		r.Reset()
+	}
+	return writeAuthenticate(w, s.Mech.Name())
+}
+
+// Account returns account name from RPL_LOGGEDIN.
+func (s *SASL) Account() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.account
+}
+
+// Finished reports whether authentication finished.
+func (s *SASL) Finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished
+}
+
+// Err returns the error of a finished authentication.
+func (s *SASL) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// ServeIRC handles AUTHENTICATE and SASL numerics.
+func (s *SASL) ServeIRC(w Writer, msg *Msg) {
+	_ = s.handle(w, msg)
+}
+
+func (s *SASL) handle(w Writer, msg *Msg) (err error) {
+	params := msg.Params()
+
+	switch string(msg.Cmd()) {
+	case AUTHENTICATE:
+		p := msg.Trailing()
+		if p == nil && len(params) > 0 {
+			p = params[0]
+		}
+
+		s.mu.Lock()
+		if string(p) != "+" {
+			s.challenge = append(s.challenge, p...)
+		}
+		if len(p) == maxAuthenticate {
+			// more to come
+			s.mu.Unlock()
+			return
+		}
+		challenge := s.challenge
+		s.challenge = nil
+		s.mu.Unlock()
+
+		var resp []byte
+		if challenge, err = base64.StdEncoding.DecodeString(string(challenge)); err != nil {
+			writeAuthenticate(w, "*")
+			return s.finish(w, err)
+		}
+		if resp, err = s.Mech.Next(challenge); err != nil {
+			writeAuthenticate(w, "*")
+			return s.finish(w, err)
+		}
+		return writeAuthenticateResponse(w, resp)
+
+	case RPL_LOGGEDIN:
+		if len(params) > 2 {
+			s.mu.Lock()
+			s.account = string(params[2])
+			s.mu.Unlock()
+		}
+
+	case RPL_SASLSUCCESS:
+		if c, ok := s.Mech.(SASLCompleter); ok && !c.Complete() {
+			return s.finish(w, &SASLError{Numeric: RPL_SASLSUCCESS, Message: "server not verified by " + s.Mech.Name()})
+		}
+		return s.finish(w, nil)
+
+	case RPL_SASLMECHS:
+		if len(params) > 1 {
+			s.mu.Lock()
+			s.mechs = strings.Split(string(params[1]), ",")
+			s.mu.Unlock()
+		}
+
+	case ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED, ERR_SASLALREADY, RPL_NICKLOCKED:
+		s.mu.Lock()
+		e := &SASLError{Numeric: string(msg.Cmd()), Message: string(msg.Trailing()), Mechs: s.mechs}
+		s.mu.Unlock()
+		return s.finish(w, e)
+	}
+	return
+}
+
+func (s *SASL) finish(w Writer, err error) error {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+	s.finished = true
+	s.err = err
+	done := s.Done
+	s.mu.Unlock()
+
+	if done != nil {
+		done(w, err)
+	}
+	return nil
+}
+
+func writeAuthenticate(w Writer, arg string) (err error) {
+	msg := new(Msg)
+	msg.SetCmd([]byte(AUTHENTICATE))
+	msg.SetParams([]byte(arg))
+	_, err = w.Encode(msg)
+	return
+}
+
+// writeAuthenticateResponse sends resp in base64 by 400 bytes chunks.
+func writeAuthenticateResponse(w Writer, resp []byte) (err error) {
+	b := base64.StdEncoding.EncodeToString(resp)
+	for len(b) >= maxAuthenticate {
+		if err = writeAuthenticate(w, b[:maxAuthenticate]); err != nil {
+			return
+		}
+		b = b[maxAuthenticate:]
+	}
+	if b == "" {
+		b = "+"
+	}
+	return writeAuthenticate(w, b)
+}
+
+// Plain is the SASL PLAIN mechanism.
+type Plain struct {
+	Identity string // authorization identity, usually empty
+	User     string
+	Password string
+}
+
+func (p *Plain) Name() string {
+	return "PLAIN"
+}
+
+func (p *Plain) Next(challenge []byte) ([]byte, error) {
+	return []byte(p.Identity + "\x00" + p.User + "\x00" + p.Password), nil
+}
+
+// External is the SASL EXTERNAL mechanism, the server authenticates by
+// TLS client certificate.
+type External struct {
+	Identity string // authorization identity, usually empty
+}
+
+func (e *External) Name() string {
+	return "EXTERNAL"
+}
+
+func (e *External) Next(challenge []byte) ([]byte, error) {
+	return []byte(e.Identity), nil
+}
+
+// ScramSHA256 is the SASL SCRAM-SHA-256 mechanism defined by RFC 7677.
+type ScramSHA256 struct {
+	Identity string // authorization identity, usually empty
+	User     string
+	Password string
+
+	nonce       string // fixed client nonce for tests, random if empty
+	clientNonce string // of current authentication
+	step        int
+	clientFirst string // client-first-message-bare
+	serverSig   []byte
+	verified    bool // server signature matched
+}
+
+// Reset prepares s for a new authentication with a fresh nonce.
+func (s *ScramSHA256) Reset() {
+	s.clientNonce, s.step, s.clientFirst, s.serverSig, s.verified = "", 0, "", nil, false
+}
+
+// Complete reports whether the server proved it knows the password.
+func (s *ScramSHA256) Complete() bool {
+	return s.verified
+}
+
+func (s *ScramSHA256) Name() string {
+	return "SCRAM-SHA-256"
+}
+
+var scramEscaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func (s *ScramSHA256) Next(challenge []byte) (resp []byte, err error) {
+	s.step++
+	switch s.step {
+	case 1:
+		s.clientNonce = s.nonce
+		if s.clientNonce == "" {
+			b := make([]byte, 18)
+			if _, err = rand.Read(b); err != nil {
+				return
+			}
+			s.clientNonce = base64.RawStdEncoding.EncodeToString(b)
+		}
+		s.clientFirst = "n=" + scramEscaper.Replace(s.User) + ",r=" + s.clientNonce
+		return []byte(s.gs2Header() + s.clientFirst), nil
+	case 2:
+		return s.clientFinal(challenge)
+	case 3:
+		attrs := scramAttrs(challenge)
+		if e, ok := attrs['e']; ok {
+			return nil, errors.New("irc: SCRAM server error " + e)
+		}
+		sig, err := base64.StdEncoding.DecodeString(attrs['v'])
+		if err != nil || !hmac.Equal(sig, s.serverSig) {
+			return nil, errors.New("irc: SCRAM invalid server signature")
+		}
+		s.verified = true
+		return nil, nil
+	}
+	return nil, errors.New("irc: SCRAM unexpected challenge")
+}
+
+func (s *ScramSHA256) gs2Header() string {
+	if s.Identity == "" {
+		return "n,,"
+	}
+	return "n,a=" + scramEscaper.Replace(s.Identity) + ","
+}
+
+func (s *ScramSHA256) clientFinal(serverFirst []byte) (resp []byte, err error) {
+	attrs := scramAttrs(serverFirst)
+	nonce := attrs['r']
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return nil, errors.New("irc: SCRAM invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs['s'])
+	if err != nil {
+		return nil, errors.New("irc: SCRAM invalid salt")
+	}
+	iter, err := strconv.Atoi(attrs['i'])
+	if err != nil || iter <= 0 {
+		return nil, errors.New("irc: SCRAM invalid iteration count")
+	}
+
+	salted := pbkdf2SHA256([]byte(s.Password), salt, iter)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	final := "c=" + base64.StdEncoding.EncodeToString([]byte(s.gs2Header())) + ",r=" + nonce
+	authMsg := []byte(s.clientFirst + "," + string(serverFirst) + "," + final)
+
+	proof := hmacSHA256(storedKey[:], authMsg)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSig = hmacSHA256(hmacSHA256(salted, []byte("Server Key")), authMsg)
+
+	return []byte(final + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func scramAttrs(b []byte) map[byte]string {
+	attrs := make(map[byte]string)
+	for _, f := range bytes.Split(b, []byte{','}) {
+		if len(f) > 1 && f[1] == '=' {
+			attrs[f[0]] = string(f[2:])
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives one block (32 bytes) key by PBKDF2-HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iter int) []byte {
+	h := hmac.New(sha256.New, password)
+	h.Write(salt)
+	h.Write([]byte{0, 0, 0, 1})
+	u := h.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < iter; i++ {
+		h.Reset()
+		h.Write(u)
+		u = h.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,209 @@
+package irc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestScramSHA256(t *testing.T) {
+	// RFC 7677 section 3
+	s := &ScramSHA256{User: "user", Password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+
+	resp, err := s.Next(nil)
+	if err != nil || string(resp) != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatal(string(resp), err)
+	}
+
+	resp, err = s.Next(s2b("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if err != nil || string(resp) != want {
+		t.Fatal(string(resp), err)
+	}
+
+	resp, err = s.Next(s2b("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+	if err != nil || len(resp) != 0 {
+		t.Fatal(string(resp), err)
+	}
+}
+
+func TestScramSHA256BadServer(t *testing.T) {
+	s := &ScramSHA256{User: "user", Password: "pencil", nonce: "abc"}
+	s.Next(nil)
+	if _, err := s.Next(s2b("r=xyz,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")); err == nil {
+		t.Error("accepted bad nonce")
+	}
+
+	s = &ScramSHA256{User: "user", Password: "pencil", nonce: "abc"}
+	s.Next(nil)
+	s.Next(s2b("r=abcdef,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=1"))
+	if _, err := s.Next(s2b("v=AAAA")); err == nil {
+		t.Error("accepted bad signature")
+	}
+}
+
+func TestSASLPlain(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewEncoder(buf)
+
+	var done bool
+	var doneErr error
+	s := &SASL{
+		Mech: &Plain{Identity: "jilles", User: "jilles", Password: "sesame"},
+		Done: func(w Writer, err error) { done, doneErr = true, err },
+	}
+	s.Start(w)
+	for _, l := range []string{
+		"AUTHENTICATE +",
+		":srv 900 jilles jilles!jilles@localhost.stack.nl jilles :You are now logged in as jilles",
+		":srv 903 jilles :SASL authentication successful",
+	} {
+		msg, _ := NewMsg(s2b(l))
+		s.ServeIRC(w, msg)
+	}
+
+	want := "AUTHENTICATE PLAIN\r\nAUTHENTICATE amlsbGVzAGppbGxlcwBzZXNhbWU=\r\n"
+	if buf.String() != want {
+		t.Errorf("%q", buf.String())
+	}
+	if !done || doneErr != nil || s.Err() != nil || s.Account() != "jilles" {
+		t.Error(done, doneErr, s.Account())
+	}
+}
+
+func TestSASLFail(t *testing.T) {
+	w := NewEncoder(bytes.NewBuffer(nil))
+	s := &SASL{Mech: &Plain{User: "a", Password: "b"}}
+	for _, l := range []string{
+		"AUTHENTICATE +",
+		":srv 908 a PLAIN,EXTERNAL :are available SASL mechanisms",
+		":srv 904 a :SASL authentication failed",
+	} {
+		msg, _ := NewMsg(s2b(l))
+		s.ServeIRC(w, msg)
+	}
+	e, ok := s.Err().(*SASLError)
+	if !ok || e.Numeric != ERR_SASLFAIL || len(e.Mechs) != 2 || e.Mechs[1] != "EXTERNAL" {
+		t.Error(s.Err())
+	}
+}
+
+func TestSASLScramUnverified(t *testing.T) {
+	w := NewEncoder(bytes.NewBuffer(nil))
+	b64 := base64.StdEncoding.EncodeToString
+	s := &SASL{Mech: &ScramSHA256{User: "user", Password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}}
+	s.Start(w)
+	for _, l := range []string{
+		"AUTHENTICATE +",
+		"AUTHENTICATE " + b64(s2b("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")),
+		// server-final with v= skipped
+		":srv 903 user :SASL authentication successful",
+	} {
+		msg, _ := NewMsg(s2b(l))
+		s.ServeIRC(w, msg)
+	}
+	if e, ok := s.Err().(*SASLError); !ok || e.Numeric != RPL_SASLSUCCESS {
+		t.Error(s.Err())
+	}
+}
+
+type echoMech struct {
+	got []byte
+}
+
+func (m *echoMech) Name() string { return "ECHO" }
+
+func (m *echoMech) Next(challenge []byte) ([]byte, error) {
+	m.got = challenge
+	return challenge, nil
+}
+
+func TestSASLChunks(t *testing.T) {
+	for _, size := range []int{299, 300, 301, 600} {
+		buf := bytes.NewBuffer(nil)
+		w := NewEncoder(buf)
+		mech := new(echoMech)
+		s := &SASL{Mech: mech}
+
+		payload := bytes.Repeat([]byte("x"), size)
+		b64 := base64.StdEncoding.EncodeToString(payload)
+		var lines []string
+		for len(b64) >= maxAuthenticate {
+			lines = append(lines, "AUTHENTICATE "+b64[:maxAuthenticate])
+			b64 = b64[maxAuthenticate:]
+		}
+		if b64 == "" {
+			b64 = "+"
+		}
+		lines = append(lines, "AUTHENTICATE "+b64)
+
+		for _, l := range lines {
+			msg, _ := NewMsg(s2b(l))
+			s.ServeIRC(w, msg)
+		}
+		if !bytes.Equal(mech.got, payload) {
+			t.Error(size, len(mech.got))
+		}
+		if got := strings.TrimSpace(buf.String()); got != strings.Join(lines, "\r\n") {
+			t.Errorf("%d: %q", size, got)
+		}
+	}
+}
+
+func TestClientSASL(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot", SASL: &Plain{User: "bot", Password: "pw"}})
+	go func() {
+		srv.expect("CAP LS 302")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv CAP * LS :sasl=PLAIN")
+		srv.expect("CAP REQ :sasl")
+		srv.send(":srv CAP * ACK :sasl")
+		srv.expect("AUTHENTICATE PLAIN")
+		srv.send("AUTHENTICATE +")
+		srv.expect("AUTHENTICATE AGJvdABwdw==")
+		srv.send(":srv 903 bot :SASL authentication successful")
+		srv.expect("CAP END")
+		srv.send(":srv 001 bot :Welcome")
+	}()
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSASLFail(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot", SASL: &External{}})
+	go func() {
+		srv.expect("CAP LS 302")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv CAP * LS :sasl")
+		srv.expect("CAP REQ :sasl")
+		srv.send(":srv CAP * ACK :sasl")
+		srv.expect("AUTHENTICATE EXTERNAL")
+		srv.send("AUTHENTICATE +")
+		srv.expect("AUTHENTICATE +")
+		srv.send(":srv 904 bot :SASL authentication failed")
+		srv.expect("CAP END")
+	}()
+	if _, ok := c.Register().(*SASLError); !ok {
+		t.Error("expect SASLError")
+	}
+}
+
+func TestClientSASLFailBeforeAck(t *testing.T) {
+	srv, conn := newFakeServer(t)
+	c := NewClient(conn, &Config{Nick: "bot", SASL: &External{}})
+	go func() {
+		srv.expect("CAP LS 302")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv 904 bot :SASL authentication failed")
+	}()
+	if _, ok := c.Register().(*SASLError); !ok {
+		t.Error("expect SASLError")
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+// flagModes are channel modes without argument.
+const flagModes = "imnpst"
+
+type member struct {
+	c         *Client
+	op, voice bool
+}
+
+// prefix returns the highest membership symbol.
+func (m *member) prefix() string {
+	switch {
+	case m.op:
+		return "@"
+	case m.voice:
+		return "+"
+	}
+	return ""
+}
+
+type channel struct {
+	name      string
+	topic     string
+	topicBy   string
+	topicTime time.Time
+	modes     map[byte]bool
+	key       string
+	limit     int
+	bans      []string
+	members   []*member // in join order
+}
+
+func newChannel(name string) *channel {
+	return &channel{
+		name:  name,
+		modes: map[byte]bool{'n': true, 't': true},
+	}
+}
+
+func (ch *channel) member(c *Client) *member {
+	for _, m := range ch.members {
+		if m.c == c {
+			return m
+		}
+	}
+	return nil
+}
+
+func (ch *channel) add(c *Client) *member {
+	m := &member{c: c}
+	ch.members = append(ch.members, m)
+	return m
+}
+
+func (ch *channel) remove(c *Client) {
+	for i, m := range ch.members {
+		if m.c == c {
+			ch.members = append(ch.members[:i], ch.members[i+1:]...)
+			return
+		}
+	}
+}
+
+func (ch *channel) secret() bool {
+	return ch.modes['s'] || ch.modes['p']
+}
+
+func (ch *channel) banned(c *Client) bool {
+	mask := c.mask()
+	for _, ban := range ch.bans {
+		if match(ban, mask) {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse returns the numeric and mode refusing c to join with key.
+func (ch *channel) refuse(c *Client, key string) (numeric string, mode byte) {
+	invited := c.invites.Has(ch.name)
+	switch {
+	case ch.key != "" && key != ch.key:
+		return irc.ERR_BADCHANNELKEY, 'k'
+	case ch.limit > 0 && len(ch.members) >= ch.limit:
+		return irc.ERR_CHANNELISFULL, 'l'
+	case ch.modes['i'] && !invited:
+		return irc.ERR_INVITEONLYCHAN, 'i'
+	case ch.banned(c) && !invited:
+		return irc.ERR_BANNEDFROMCHAN, 'b'
+	}
+	return "", 0
+}
+
+// canSend reports whether c can send msgs to channel.
+func (ch *channel) canSend(c *Client) bool {
+	m := ch.member(c)
+	if m != nil && (m.op || m.voice) {
+		return true
+	}
+	if m == nil && ch.modes['n'] || ch.modes['m'] {
+		return false
+	}
+	return !ch.banned(c)
+}
+
+// modeString returns the modes and their args, the key is hidden unless
+// withKey.
+func (ch *channel) modeString(withKey bool) (modes string, args []string) {
+	b := []byte{'+'}
+	for i := 0; i < len(flagModes); i++ {
+		if ch.modes[flagModes[i]] {
+			b = append(b, flagModes[i])
+		}
+	}
+	if ch.key != "" {
+		b = append(b, 'k')
+		key := "*"
+		if withKey {
+			key = ch.key
+		}
+		args = append(args, key)
+	}
+	if ch.limit > 0 {
+		b = append(b, 'l')
+		args = append(args, strconv.Itoa(ch.limit))
+	}
+	return string(b), args
+}
+
+func (ch *channel) record() ChannelRecord {
+	modes, _ := ch.modeString(false)
+	return ChannelRecord{
+		Name:      ch.name,
+		Topic:     ch.topic,
+		TopicBy:   ch.topicBy,
+		TopicTime: ch.topicTime,
+		Modes:     modes[1:],
+		Key:       ch.key,
+		Limit:     ch.limit,
+		Bans:      append([]string(nil), ch.bans...),
+	}
+}
+
+func (ch *channel) load(rec ChannelRecord) {
+	ch.topic, ch.topicBy, ch.topicTime = rec.Topic, rec.TopicBy, rec.TopicTime
+	ch.modes = make(map[byte]bool)
+	for i := 0; i < len(rec.Modes); i++ {
+		ch.modes[rec.Modes[i]] = true
+	}
+	ch.key, ch.limit = rec.Key, rec.Limit
+	ch.bans = append([]string(nil), rec.Bans...)
+}
+
+// channelNames returns sorted names of channels of c visible to other.
+func channelNames(c, other *Client) (names []string) {
+	for ch := range c.channels {
+		if ch.secret() && ch.member(other) == nil {
+			continue
+		}
+		names = append(names, ch.member(c).prefix()+ch.name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func sortChannels(chs []*channel) {
+	sort.Slice(chs, func(i, j int) bool { return chs[i].name < chs[j].name })
+}
+
+func sortClients(cs []*Client) {
+	sort.Slice(cs, func(i, j int) bool { return cs[i].nick < cs[j].nick })
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+var errSendQ = errors.New("server: sendq exceeded")
+
+// sendQueue is written by Encoder of a client and drained by its writeLoop.
+type sendQueue chan []byte
+
+func (q sendQueue) Write(p []byte) (int, error) {
+	select {
+	case q <- append([]byte(nil), p...):
+		return len(p), nil
+	default:
+		return 0, errSendQ
+	}
+}
+
+// Client is a connection to the server.
+type Client struct {
+	srv  *Server
+	conn net.Conn
+	send sendQueue
+	enc  *irc.Encoder
+
+	// guarded by srv.mu, written only by the goroutine serving conn
+	nick, user, host, realName string
+	pass                       string
+	registered                 bool
+	capNeg                     bool
+	invisible                  bool
+	channels                   map[*channel]struct{}
+	invites                    *irc.FoldSet
+	signon, idle               time.Time
+	quitMsg                    string
+	closed                     bool
+}
+
+func newClient(s *Server, conn net.Conn) *Client {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+	c := &Client{
+		srv:      s,
+		conn:     conn,
+		send:     make(sendQueue, s.SendQ),
+		host:     host,
+		channels: make(map[*channel]struct{}),
+		invites:  irc.NewFoldSet(irc.RFC1459),
+	}
+	c.enc = irc.NewEncoder(c.send)
+	return c
+}
+
+// Nick returns nick of c, empty before NICK.
+func (c *Client) Nick() string {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.nick
+}
+
+// User returns username of c from USER.
+func (c *Client) User() string {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.user
+}
+
+// Host returns host of c.
+func (c *Client) Host() string {
+	return c.host
+}
+
+// RealName returns real name of c from USER.
+func (c *Client) RealName() string {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.realName
+}
+
+// RemoteAddr returns the address of c.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Client) mask() string {
+	return c.nick + "!" + c.user + "@" + c.host
+}
+
+// target returns nick of c for numerics, "*" before NICK.
+func (c *Client) target() string {
+	if c.nick == "" {
+		return "*"
+	}
+	return c.nick
+}
+
+// msg returns a msg of cmd from c.
+func (c *Client) msg(cmd string, params ...string) *irc.Msg {
+	return newMsg(c.nick, c.user, c.host, cmd, params)
+}
+
+// encode queues msg, c is disconnected if its queue is full.
+func (c *Client) encode(msg *irc.Msg) {
+	if c.closed {
+		return
+	}
+	if _, err := c.enc.Encode(msg); err == errSendQ && c.quitMsg == "" {
+		c.quitMsg = "SendQ exceeded"
+		c.conn.Close()
+	}
+}
+
+func (c *Client) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// writeLoop writes queued msgs until queue is closed, then closes conn.
+func (c *Client) writeLoop() {
+	var err error
+	for p := range c.send {
+		if err == nil {
+			_, err = c.conn.Write(p)
+		}
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,725 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+type command struct {
+	minArgs    int
+	registered bool // needs registration
+	handle     func(s *Server, c *Client, args []string)
+}
+
+var commands = map[string]command{
+	irc.CAP:     {1, false, (*Server).cap},
+	irc.PASS:    {1, false, (*Server).pass},
+	irc.NICK:    {0, false, (*Server).nick},
+	irc.USER:    {4, false, (*Server).user},
+	irc.PING:    {0, false, (*Server).ping},
+	irc.PONG:    {0, false, func(*Server, *Client, []string) {}},
+	irc.QUIT:    {0, false, (*Server).quitCmd},
+	irc.JOIN:    {1, true, (*Server).join},
+	irc.PART:    {1, true, (*Server).part},
+	irc.PRIVMSG: {0, true, (*Server).privmsg},
+	irc.NOTICE:  {0, true, (*Server).notice},
+	irc.TOPIC:   {1, true, (*Server).topic},
+	irc.NAMES:   {0, true, (*Server).names},
+	irc.LIST:    {0, true, (*Server).list},
+	irc.KICK:    {2, true, (*Server).kick},
+	irc.INVITE:  {2, true, (*Server).invite},
+	irc.MODE:    {1, true, (*Server).mode},
+	irc.WHO:     {0, true, (*Server).who},
+	irc.WHOIS:   {1, true, (*Server).whois},
+	irc.MOTD:    {0, true, func(s *Server, c *Client, _ []string) { s.motd(c) }},
+}
+
+// handle runs the command of msg from c with server locked, it reports
+// whether c is quitting.
+func (s *Server) handle(c *Client, msg *irc.Msg) (quit bool) {
+	name := strings.ToUpper(string(msg.Cmd()))
+	var args []string
+	for _, p := range msg.Params() {
+		args = append(args, string(p))
+	}
+	if t := msg.Trailing(); t != nil {
+		args = append(args, string(t))
+	}
+
+	cmd, ok := commands[name]
+	switch {
+	case !ok && c.registered:
+		s.reply(c, irc.ERR_UNKNOWNCOMMAND, name, "Unknown command")
+	case !ok, cmd.registered && !c.registered:
+		s.reply(c, irc.ERR_NOTREGISTERED, "You have not registered")
+	case len(args) < cmd.minArgs:
+		s.reply(c, irc.ERR_NEEDMOREPARAMS, name, "Not enough parameters")
+	default:
+		cmd.handle(s, c, args)
+	}
+	return c.quitMsg != ""
+}
+
+// capServerTime is the only cap, see
+// https://ircv3.net/specs/extensions/server-time
+const capServerTime = "server-time"
+
+// cap answers CAP negotiation offering server-time, registration waits for
+// CAP END once negotiation started.
+func (s *Server) cap(c *Client, args []string) {
+	switch sub := strings.ToUpper(args[0]); sub {
+	case "LS", "LIST":
+		if !c.registered {
+			c.capNeg = true
+		}
+		caps := capServerTime
+		if sub == "LIST" && c.enc.ServerTime == nil {
+			caps = ""
+		}
+		c.encode(s.msg(irc.CAP, c.target(), sub, caps))
+	case "REQ":
+		if !c.registered {
+			c.capNeg = true
+		}
+		req := ""
+		if len(args) > 1 {
+			req = args[1]
+		}
+		// all or nothing
+		names := strings.Fields(req)
+		enable, ok := c.enc.ServerTime != nil, len(names) != 0
+		for _, name := range names {
+			switch name {
+			case capServerTime:
+				enable = true
+			case "-" + capServerTime:
+				enable = false
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			c.encode(s.msg(irc.CAP, c.target(), "NAK", req))
+			return
+		}
+		if enable {
+			c.enc.ServerTime = s.Clock
+		} else {
+			c.enc.ServerTime = nil
+		}
+		c.encode(s.msg(irc.CAP, c.target(), "ACK", req))
+	case "END":
+		c.capNeg = false
+	}
+}
+
+func (s *Server) pass(c *Client, args []string) {
+	if c.registered {
+		s.reply(c, irc.ERR_ALREADYREGISTRED, "You may not reregister")
+		return
+	}
+	c.pass = args[0]
+}
+
+func (s *Server) nick(c *Client, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		s.reply(c, irc.ERR_NONICKNAMEGIVEN, "No nickname given")
+		return
+	}
+	nick := args[0]
+	if !validNick(nick) {
+		s.reply(c, irc.ERR_ERRONEUSNICKNAME, nick, "Erroneous nickname")
+		return
+	}
+	if other, ok := s.clients.Get(nick); ok && other != c {
+		s.reply(c, irc.ERR_NICKNAMEINUSE, nick, "Nickname is already in use")
+		return
+	}
+	if !c.registered {
+		c.nick = nick
+		return
+	}
+	if nick == c.nick {
+		return
+	}
+	msg := c.msg(irc.NICK, nick)
+	c.encode(msg)
+	for peer := range s.peers(c) {
+		peer.encode(msg)
+	}
+	s.clients.Rename(c.nick, nick)
+	c.nick = nick
+}
+
+func (s *Server) user(c *Client, args []string) {
+	if c.registered {
+		s.reply(c, irc.ERR_ALREADYREGISTRED, "You may not reregister")
+		return
+	}
+	c.user, c.realName = args[0], args[3]
+}
+
+func (s *Server) ping(c *Client, args []string) {
+	if len(args) == 0 {
+		s.reply(c, irc.ERR_NOORIGIN, "No origin specified")
+		return
+	}
+	c.encode(s.msg(irc.PONG, s.Name, args[0]))
+}
+
+func (s *Server) quitCmd(c *Client, args []string) {
+	c.quitMsg = "Client Quit"
+	if len(args) > 0 {
+		c.quitMsg = "Quit: " + args[0]
+	}
+}
+
+func (s *Server) join(c *Client, args []string) {
+	if args[0] == "0" {
+		for ch := range c.channels {
+			s.broadcast(ch, c.msg(irc.PART, ch.name, c.nick), nil)
+			s.leave(c, ch)
+		}
+		return
+	}
+	var keys []string
+	if len(args) > 1 {
+		keys = strings.Split(args[1], ",")
+	}
+	for i, name := range strings.Split(args[0], ",") {
+		key := ""
+		if i < len(keys) {
+			key = keys[i]
+		}
+		s.joinChannel(c, name, key)
+	}
+}
+
+func (s *Server) joinChannel(c *Client, name, key string) {
+	if !validChannel(name) {
+		s.reply(c, irc.ERR_NOSUCHCHANNEL, name, "No such channel")
+		return
+	}
+	ch, ok := s.channels.Get(name)
+	if !ok {
+		ch = newChannel(name)
+		if s.Store != nil {
+			if rec, found := s.Store.LoadChannel(name); found {
+				ch.load(rec)
+			}
+		}
+	} else if ch.member(c) != nil {
+		return
+	}
+	if numeric, mode := ch.refuse(c, key); numeric != "" {
+		s.reply(c, numeric, ch.name, "Cannot join channel (+"+string(mode)+")")
+		return
+	}
+	if !ok {
+		s.channels.Set(name, ch)
+	}
+
+	c.invites.Delete(ch.name)
+	m := ch.add(c)
+	m.op = len(ch.members) == 1
+	c.channels[ch] = struct{}{}
+	s.broadcast(ch, c.msg(irc.JOIN, ch.name), nil)
+	if ch.topic != "" {
+		s.sendTopic(c, ch)
+	}
+	s.sendNames(c, ch)
+}
+
+func (s *Server) part(c *Client, args []string) {
+	for _, name := range strings.Split(args[0], ",") {
+		ch, ok := s.channels.Get(name)
+		if !ok {
+			s.reply(c, irc.ERR_NOSUCHCHANNEL, name, "No such channel")
+			continue
+		}
+		if ch.member(c) == nil {
+			s.reply(c, irc.ERR_NOTONCHANNEL, ch.name, "You're not on that channel")
+			continue
+		}
+		params := []string{ch.name}
+		if len(args) > 1 {
+			params = append(params, args[1])
+		}
+		s.broadcast(ch, c.msg(irc.PART, params...), nil)
+		s.leave(c, ch)
+	}
+}
+
+// leave removes c from ch, an emptied channel is saved and removed.
+func (s *Server) leave(c *Client, ch *channel) {
+	ch.remove(c)
+	delete(c.channels, ch)
+	if len(ch.members) == 0 {
+		s.save(ch)
+		s.channels.Delete(ch.name)
+	}
+}
+
+func (s *Server) save(ch *channel) {
+	if s.Store != nil {
+		s.Store.SaveChannel(ch.record())
+	}
+}
+
+// broadcast sends msg to members of ch except except.
+func (s *Server) broadcast(ch *channel, msg *irc.Msg, except *Client) {
+	for _, m := range ch.members {
+		if m.c != except {
+			m.c.encode(msg)
+		}
+	}
+}
+
+func (s *Server) privmsg(c *Client, args []string) {
+	s.message(c, irc.PRIVMSG, args)
+}
+
+func (s *Server) notice(c *Client, args []string) {
+	s.message(c, irc.NOTICE, args)
+}
+
+// message relays PRIVMSG or NOTICE, NOTICE never gets error replies.
+func (s *Server) message(c *Client, cmd string, args []string) {
+	reply := s.reply
+	if cmd == irc.NOTICE {
+		reply = func(*Client, string, ...string) {}
+	}
+	if len(args) == 0 || args[0] == "" {
+		reply(c, irc.ERR_NORECIPIENT, "No recipient given ("+cmd+")")
+		return
+	}
+	if len(args) < 2 || args[1] == "" {
+		reply(c, irc.ERR_NOTEXTTOSEND, "No text to send")
+		return
+	}
+	c.idle = s.Clock.Now()
+	text := args[1]
+	for _, target := range strings.Split(args[0], ",") {
+		if isChannel(target) {
+			ch, ok := s.channels.Get(target)
+			switch {
+			case !ok:
+				reply(c, irc.ERR_NOSUCHNICK, target, "No such nick/channel")
+			case !ch.canSend(c):
+				reply(c, irc.ERR_CANNOTSENDTOCHAN, ch.name, "Cannot send to channel")
+			default:
+				s.broadcast(ch, c.msg(cmd, ch.name, text), c)
+			}
+			continue
+		}
+		t, ok := s.clients.Get(target)
+		if !ok {
+			reply(c, irc.ERR_NOSUCHNICK, target, "No such nick/channel")
+			continue
+		}
+		t.encode(c.msg(cmd, t.nick, text))
+	}
+}
+
+func (s *Server) sendTopic(c *Client, ch *channel) {
+	s.reply(c, irc.RPL_TOPIC, ch.name, ch.topic)
+	if ch.topicBy != "" {
+		s.reply(c, irc.RPL_TOPICWHOTIME, ch.name, ch.topicBy,
+			strconv.FormatInt(ch.topicTime.Unix(), 10))
+	}
+}
+
+func (s *Server) topic(c *Client, args []string) {
+	ch, ok := s.channels.Get(args[0])
+	if !ok {
+		s.reply(c, irc.ERR_NOSUCHCHANNEL, args[0], "No such channel")
+		return
+	}
+	m := ch.member(c)
+	if len(args) == 1 {
+		switch {
+		case ch.secret() && m == nil:
+			s.reply(c, irc.ERR_NOTONCHANNEL, ch.name, "You're not on that channel")
+		case ch.topic == "":
+			s.reply(c, irc.RPL_NOTOPIC, ch.name, "No topic is set")
+		default:
+			s.sendTopic(c, ch)
+		}
+		return
+	}
+	if m == nil {
+		s.reply(c, irc.ERR_NOTONCHANNEL, ch.name, "You're not on that channel")
+		return
+	}
+	if ch.modes['t'] && !m.op {
+		s.reply(c, irc.ERR_CHANOPRIVSNEEDED, ch.name, "You're not channel operator")
+		return
+	}
+	topic := args[1]
+	if len(topic) > topicLen {
+		topic = topic[:topicLen]
+	}
+	ch.topic, ch.topicBy, ch.topicTime = topic, c.nick, s.Clock.Now()
+	s.broadcast(ch, c.msg(irc.TOPIC, ch.name, topic), nil)
+	s.save(ch)
+}
+
+func (s *Server) names(c *Client, args []string) {
+	if len(args) == 0 {
+		s.reply(c, irc.RPL_ENDOFNAMES, "*", "End of /NAMES list.")
+		return
+	}
+	for _, name := range strings.Split(args[0], ",") {
+		ch, ok := s.channels.Get(name)
+		if !ok || ch.secret() && ch.member(c) == nil {
+			s.reply(c, irc.RPL_ENDOFNAMES, name, "End of /NAMES list.")
+			continue
+		}
+		s.sendNames(c, ch)
+	}
+}
+
+// sendNames sends RPL_NAMREPLY lines of ch to c.
+func (s *Server) sendNames(c *Client, ch *channel) {
+	symbol := "="
+	if ch.secret() {
+		symbol = "@"
+	}
+	// :server 353 nick = #channel :names\r\n
+	max := irc.MaxLineLen - len(s.Name) - len(c.target()) - len(ch.name) - 14
+	var line []byte
+	for _, m := range ch.members {
+		name := m.prefix() + m.c.nick
+		if len(line) > 0 && len(line)+1+len(name) > max {
+			s.reply(c, irc.RPL_NAMREPLY, symbol, ch.name, string(line))
+			line = line[:0]
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, name...)
+	}
+	if len(line) > 0 {
+		s.reply(c, irc.RPL_NAMREPLY, symbol, ch.name, string(line))
+	}
+	s.reply(c, irc.RPL_ENDOFNAMES, ch.name, "End of /NAMES list.")
+}
+
+func (s *Server) list(c *Client, args []string) {
+	s.reply(c, irc.RPL_LISTSTART, "Channel", "Users  Name")
+	send := func(ch *channel) {
+		if !ch.secret() || ch.member(c) != nil {
+			s.reply(c, irc.RPL_LIST, ch.name, strconv.Itoa(len(ch.members)), ch.topic)
+		}
+	}
+	if len(args) > 0 && args[0] != "" {
+		for _, name := range strings.Split(args[0], ",") {
+			if ch, ok := s.channels.Get(name); ok {
+				send(ch)
+			}
+		}
+	} else {
+		var chs []*channel
+		s.channels.Range(func(_ string, ch *channel) bool {
+			chs = append(chs, ch)
+			return true
+		})
+		sortChannels(chs)
+		for _, ch := range chs {
+			send(ch)
+		}
+	}
+	s.reply(c, irc.RPL_LISTEND, "End of /LIST")
+}
+
+func (s *Server) kick(c *Client, args []string) {
+	ch, ok := s.channels.Get(args[0])
+	if !ok {
+		s.reply(c, irc.ERR_NOSUCHCHANNEL, args[0], "No such channel")
+		return
+	}
+	m := ch.member(c)
+	if m == nil {
+		s.reply(c, irc.ERR_NOTONCHANNEL, ch.name, "You're not on that channel")
+		return
+	}
+	if !m.op {
+		s.reply(c, irc.ERR_CHANOPRIVSNEEDED, ch.name, "You're not channel operator")
+		return
+	}
+	reason := c.nick
+	if len(args) > 2 && args[2] != "" {
+		reason = args[2]
+	}
+	for _, nick := range strings.Split(args[1], ",") {
+		t, ok := s.clients.Get(nick)
+		if !ok || ch.member(t) == nil {
+			s.reply(c, irc.ERR_USERNOTINCHANNEL, nick, ch.name, "They aren't on that channel")
+			continue
+		}
+		s.broadcast(ch, c.msg(irc.KICK, ch.name, t.nick, reason), nil)
+		s.leave(t, ch)
+	}
+}
+
+func (s *Server) invite(c *Client, args []string) {
+	t, ok := s.clients.Get(args[0])
+	if !ok {
+		s.reply(c, irc.ERR_NOSUCHNICK, args[0], "No such nick/channel")
+		return
+	}
+	name := args[1]
+	if ch, ok := s.channels.Get(name); ok {
+		m := ch.member(c)
+		switch {
+		case m == nil:
+			s.reply(c, irc.ERR_NOTONCHANNEL, ch.name, "You're not on that channel")
+			return
+		case ch.member(t) != nil:
+			s.reply(c, irc.ERR_USERONCHANNEL, t.nick, ch.name, "is already on channel")
+			return
+		case ch.modes['i'] && !m.op:
+			s.reply(c, irc.ERR_CHANOPRIVSNEEDED, ch.name, "You're not channel operator")
+			return
+		}
+		name = ch.name
+	}
+	t.invites.Add(name)
+	s.reply(c, irc.RPL_INVITING, t.nick, name)
+	t.encode(c.msg(irc.INVITE, t.nick, name))
+}
+
+func (s *Server) mode(c *Client, args []string) {
+	if !isChannel(args[0]) {
+		s.userMode(c, args)
+		return
+	}
+	ch, ok := s.channels.Get(args[0])
+	if !ok {
+		s.reply(c, irc.ERR_NOSUCHCHANNEL, args[0], "No such channel")
+		return
+	}
+	if len(args) == 1 {
+		modes, margs := ch.modeString(ch.member(c) != nil)
+		s.reply(c, irc.RPL_CHANNELMODEIS, append([]string{ch.name, modes}, margs...)...)
+		return
+	}
+
+	margs := make([][]byte, 0, len(args)-2)
+	for _, a := range args[2:] {
+		margs = append(margs, []byte(a))
+	}
+	changes, err := irc.ParseModes(s.isupport, []byte(args[1]), margs)
+	if err != nil {
+		s.reply(c, irc.ERR_NEEDMOREPARAMS, irc.MODE, "Not enough parameters")
+		return
+	}
+	s.channelMode(c, ch, changes)
+}
+
+func (s *Server) channelMode(c *Client, ch *channel, changes []irc.ModeChange) {
+	m := ch.member(c)
+	denied := false
+	var applied []irc.ModeChange
+	for _, mc := range changes {
+		if mc.Mode == 'b' && mc.Arg == "" {
+			for _, ban := range ch.bans {
+				s.reply(c, irc.RPL_BANLIST, ch.name, ban)
+			}
+			s.reply(c, irc.RPL_ENDOFBANLIST, ch.name, "End of channel ban list")
+			continue
+		}
+		if m == nil || !m.op {
+			if !denied {
+				s.reply(c, irc.ERR_CHANOPRIVSNEEDED, ch.name, "You're not channel operator")
+				denied = true
+			}
+			continue
+		}
+
+		switch mc.Mode {
+		case 'o', 'v':
+			t, ok := s.clients.Get(mc.Arg)
+			tm := (*member)(nil)
+			if ok {
+				tm = ch.member(t)
+			}
+			if tm == nil {
+				s.reply(c, irc.ERR_USERNOTINCHANNEL, mc.Arg, ch.name, "They aren't on that channel")
+				continue
+			}
+			if mc.Mode == 'o' {
+				tm.op = mc.Add
+			} else {
+				tm.voice = mc.Add
+			}
+			mc.Arg = t.nick
+		case 'b':
+			i := banIndex(ch.bans, mc.Arg)
+			switch {
+			case mc.Add && i < 0:
+				ch.bans = append(ch.bans, mc.Arg)
+			case !mc.Add && i >= 0:
+				mc.Arg = ch.bans[i]
+				ch.bans = append(ch.bans[:i], ch.bans[i+1:]...)
+			default:
+				continue
+			}
+		case 'k':
+			if mc.Add {
+				ch.key = mc.Arg
+			} else if ch.key != "" {
+				ch.key = ""
+			} else {
+				continue
+			}
+		case 'l':
+			if mc.Add {
+				n, err := strconv.Atoi(mc.Arg)
+				if err != nil || n <= 0 {
+					continue
+				}
+				ch.limit = n
+				mc.Arg = strconv.Itoa(n)
+			} else if ch.limit > 0 {
+				ch.limit = 0
+			} else {
+				continue
+			}
+		default:
+			if strings.IndexByte(flagModes, mc.Mode) < 0 {
+				s.reply(c, irc.ERR_UNKNOWNMODE, string(mc.Mode), "is unknown mode char to me")
+				continue
+			}
+			if ch.modes[mc.Mode] == mc.Add {
+				continue
+			}
+			ch.modes[mc.Mode] = mc.Add
+		}
+		applied = append(applied, mc)
+	}
+	if len(applied) == 0 {
+		return
+	}
+	for _, msg := range irc.ModeMsgs(s.isupport, ch.name, applied) {
+		msg.SetName([]byte(c.nick))
+		msg.SetUser([]byte(c.user))
+		msg.SetHost([]byte(c.host))
+		s.broadcast(ch, msg, nil)
+	}
+	s.save(ch)
+}
+
+func banIndex(bans []string, mask string) int {
+	for i, ban := range bans {
+		if irc.RFC1459.EqualString(ban, mask) {
+			return i
+		}
+	}
+	return -1
+}
+
+// userMode handles MODE of a nick, only 'i' is supported.
+func (s *Server) userMode(c *Client, args []string) {
+	if !irc.RFC1459.EqualString(args[0], c.nick) {
+		if _, ok := s.clients.Get(args[0]); ok {
+			s.reply(c, irc.ERR_USERSDONTMATCH, "Can't change mode for other users")
+		} else {
+			s.reply(c, irc.ERR_NOSUCHNICK, args[0], "No such nick/channel")
+		}
+		return
+	}
+	if len(args) == 1 {
+		modes := "+"
+		if c.invisible {
+			modes += "i"
+		}
+		s.reply(c, irc.RPL_UMODEIS, modes)
+		return
+	}
+
+	add, changed, unknown := true, []byte{}, false
+	for _, m := range []byte(args[1]) {
+		switch m {
+		case '+', '-':
+			add = m == '+'
+		case 'i':
+			if c.invisible != add {
+				c.invisible = add
+				if add {
+					changed = append(changed, '+', 'i')
+				} else {
+					changed = append(changed, '-', 'i')
+				}
+			}
+		default:
+			unknown = true
+		}
+	}
+	if len(changed) > 0 {
+		c.encode(c.msg(irc.MODE, c.nick, string(changed)))
+	}
+	if unknown {
+		s.reply(c, irc.ERR_UMODEUNKNOWNFLAG, "Unknown MODE flag")
+	}
+}
+
+func (s *Server) who(c *Client, args []string) {
+	mask := "*"
+	if len(args) > 0 && args[0] != "" && args[0] != "0" {
+		mask = args[0]
+	}
+	send := func(channel string, t *Client, prefix string) {
+		flags := "H" + prefix
+		s.reply(c, irc.RPL_WHOREPLY, channel, t.user, t.host, s.Name, t.nick, flags,
+			"0 "+t.realName)
+	}
+
+	if isChannel(mask) {
+		if ch, ok := s.channels.Get(mask); ok && (!ch.secret() || ch.member(c) != nil) {
+			for _, m := range ch.members {
+				send(ch.name, m.c, m.prefix())
+			}
+		}
+	} else {
+		peers := s.peers(c)
+		var matched []*Client
+		s.clients.Range(func(nick string, t *Client) bool {
+			if _, shared := peers[t]; t.invisible && !shared && t != c {
+				return true
+			}
+			if match(mask, nick) {
+				matched = append(matched, t)
+			}
+			return true
+		})
+		sortClients(matched)
+		for _, t := range matched {
+			send("*", t, "")
+		}
+	}
+	s.reply(c, irc.RPL_ENDOFWHO, mask, "End of WHO list")
+}
+
+func (s *Server) whois(c *Client, args []string) {
+	masks := args[len(args)-1]
+	for _, nick := range strings.Split(masks, ",") {
+		t, ok := s.clients.Get(nick)
+		if !ok {
+			s.reply(c, irc.ERR_NOSUCHNICK, nick, "No such nick/channel")
+			continue
+		}
+		s.reply(c, irc.RPL_WHOISUSER, t.nick, t.user, t.host, "*", t.realName)
+		if names := channelNames(t, c); len(names) > 0 {
+			s.reply(c, irc.RPL_WHOISCHANNELS, t.nick, strings.Join(names, " "))
+		}
+		s.reply(c, irc.RPL_WHOISSERVER, t.nick, s.Name, s.Network)
+		idle := int64(s.Clock.Now().Sub(t.idle) / time.Second)
+		s.reply(c, irc.RPL_WHOISIDLE, t.nick, strconv.FormatInt(idle, 10),
+			strconv.FormatInt(t.signon.Unix(), 10), "seconds idle, signon time")
+	}
+	s.reply(c, irc.RPL_ENDOFWHOIS, masks, "End of /WHOIS list.")
+}
@@ -0,0 +1,378 @@
+// Package server implements a small embeddable IRC server on top of the
+// Decoder and Encoder of package irc, for test environments and chat-ops.
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+const (
+	version      = "mengzhuo-irc"
+	nickLen      = 30
+	channelLen   = 50
+	topicLen     = 390
+	defaultSendQ = 512
+)
+
+// isupport are the RPL_ISUPPORT tokens of server, NETWORK is added if set.
+var isupport = []string{
+	"CASEMAPPING=rfc1459",
+	"CHANTYPES=#&",
+	"CHANMODES=b,k,l,imnpst",
+	"PREFIX=(ov)@+",
+	"MODES=3",
+	"NICKLEN=" + strconv.Itoa(nickLen),
+	"CHANNELLEN=" + strconv.Itoa(channelLen),
+	"TOPICLEN=" + strconv.Itoa(topicLen),
+}
+
+var ErrServerClosed = errors.New("server: closed")
+
+// Authenticator checks a client before its registration completes.
+type Authenticator interface {
+	// Authenticate is called once NICK and USER are received, password
+	// is from PASS. A non-nil error rejects the client.
+	Authenticate(c *Client, password string) error
+}
+
+// AuthFunc is an adapter to allow the use of ordinary functions as
+// Authenticator.
+type AuthFunc func(c *Client, password string) error
+
+func (f AuthFunc) Authenticate(c *Client, password string) error {
+	return f(c, password)
+}
+
+// Server is an IRC server.
+type Server struct {
+	// Name is the server name used as prefix of replies.
+	Name    string
+	Network string
+	MOTD    []string
+
+	// Auth checks clients on registration, nil accepts every client.
+	Auth Authenticator
+	// Store keeps channels between their lives, may be nil.
+	Store Store
+	Clock irc.Clock
+
+	// SendQ is the max number of msgs queued for a client, a client
+	// exceeding it is disconnected.
+	SendQ int
+
+	isupport *irc.ISupport
+	created  time.Time
+
+	mu        sync.Mutex
+	clients   *irc.FoldMap[*Client] // registered clients by nick
+	channels  *irc.FoldMap[*channel]
+	conns     map[*Client]struct{}
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+func NewServer(name string) *Server {
+	s := &Server{
+		Name:      name,
+		Clock:     irc.SystemClock,
+		SendQ:     defaultSendQ,
+		isupport:  irc.NewISupport(),
+		clients:   irc.NewFoldMap[*Client](irc.RFC1459),
+		channels:  irc.NewFoldMap[*channel](irc.RFC1459),
+		conns:     make(map[*Client]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+	}
+	s.created = s.Clock.Now()
+	msg, _ := irc.NewMsg([]byte(":" + name + " " + irc.RPL_ISUPPORT + " * " +
+		strings.Join(isupport, " ")))
+	s.isupport.Parse(msg)
+	return s
+}
+
+// ListenAndServe listens on TCP addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves each in its own goroutine until
+// l fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves conn until the client quits or conn fails.
+func (s *Server) ServeConn(conn net.Conn) {
+	c := newClient(s, conn)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	go c.writeLoop()
+
+	dec := irc.NewDecoder(conn)
+	dec.DiscardLong = true
+	msg := new(irc.Msg)
+	for {
+		if err := dec.Decode(msg); err != nil {
+			if msg.Data == nil {
+				// read error, malformed lines keep their Data
+				break
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		done := s.handle(c, msg)
+		pending := !done && !c.registered && !c.capNeg && c.nick != "" && c.user != ""
+		s.mu.Unlock()
+
+		if pending {
+			done = !s.register(c)
+		}
+		if done {
+			break
+		}
+	}
+	s.quit(c, "Connection closed")
+}
+
+// Close closes all listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// Clients returns nicks of registered clients.
+func (s *Server) Clients() (nicks []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients.Range(func(nick string, _ *Client) bool {
+		nicks = append(nicks, nick)
+		return true
+	})
+	return
+}
+
+// register authenticates c and sends the welcome burst, it reports whether
+// c is still connected.
+func (s *Server) register(c *Client) bool {
+	if s.Auth != nil {
+		// c is only written by its own goroutine, no lock for reading
+		if err := s.Auth.Authenticate(c, c.pass); err != nil {
+			s.mu.Lock()
+			s.reply(c, irc.ERR_PASSWDMISMATCH, "Password incorrect")
+			c.quitMsg = "Bad password"
+			s.mu.Unlock()
+			return false
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients.Get(c.nick); ok {
+		// taken while authenticating
+		s.reply(c, irc.ERR_NICKNAMEINUSE, c.nick, "Nickname is already in use")
+		c.nick = ""
+		return true
+	}
+	c.registered = true
+	c.signon = s.Clock.Now()
+	c.idle = c.signon
+	s.clients.Set(c.nick, c)
+
+	network := s.Network
+	if network == "" {
+		network = s.Name
+	}
+	s.reply(c, irc.RPL_WELCOME, "Welcome to the "+network+" IRC Network "+c.mask())
+	s.reply(c, irc.RPL_YOURHOST, "Your host is "+s.Name+", running version "+version)
+	s.reply(c, irc.RPL_CREATED, "This server was created "+s.created.Format(time.RFC1123))
+	s.reply(c, irc.RPL_MYINFO, s.Name, version, "i", "biklmnopstv")
+	tokens := isupport
+	if s.Network != "" {
+		tokens = append(tokens[:len(tokens):len(tokens)], "NETWORK="+s.Network)
+	}
+	s.reply(c, irc.RPL_ISUPPORT, append(tokens, "are supported by this server")...)
+	s.motd(c)
+	return true
+}
+
+// quit removes c from server and closes its connection.
+func (s *Server) quit(c *Client, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.quitMsg != "" {
+		reason = c.quitMsg
+	}
+	if c.registered {
+		msg := c.msg(irc.QUIT, reason)
+		for peer := range s.peers(c) {
+			peer.encode(msg)
+		}
+		for ch := range c.channels {
+			s.leave(c, ch)
+		}
+		s.clients.Delete(c.nick)
+	}
+	c.encode(s.msg(irc.ERROR, "Closing Link: "+c.host+" ("+reason+")"))
+	delete(s.conns, c)
+	c.close()
+}
+
+// peers returns clients sharing a channel with c.
+func (s *Server) peers(c *Client) map[*Client]struct{} {
+	peers := make(map[*Client]struct{})
+	for ch := range c.channels {
+		for _, m := range ch.members {
+			if m.c != c {
+				peers[m.c] = struct{}{}
+			}
+		}
+	}
+	return peers
+}
+
+// msg returns a msg of cmd from server.
+func (s *Server) msg(cmd string, params ...string) *irc.Msg {
+	return newMsg(s.Name, "", "", cmd, params)
+}
+
+// reply sends numeric with params to c.
+func (s *Server) reply(c *Client, numeric string, params ...string) {
+	c.encode(s.msg(numeric, append([]string{c.target()}, params...)...))
+}
+
+func (s *Server) motd(c *Client) {
+	if len(s.MOTD) == 0 {
+		s.reply(c, irc.ERR_NOMOTD, "MOTD File is missing")
+		return
+	}
+	s.reply(c, irc.RPL_MOTDSTART, "- "+s.Name+" Message of the day - ")
+	for _, line := range s.MOTD {
+		s.reply(c, irc.RPL_MOTD, "- "+line)
+	}
+	s.reply(c, irc.RPL_ENDOFMOTD, "End of /MOTD command.")
+}
+
+// newMsg returns a msg of cmd from nick!user@host, the last param is sent
+// as trailing.
+func newMsg(nick, user, host, cmd string, params []string) *irc.Msg {
+	msg := new(irc.Msg)
+	msg.SetName([]byte(nick))
+	if user != "" {
+		msg.SetUser([]byte(user))
+		msg.SetHost([]byte(host))
+	}
+	msg.SetCmd([]byte(cmd))
+	if n := len(params); n > 0 {
+		for _, p := range params[:n-1] {
+			msg.AppendParams([]byte(p))
+		}
+		msg.SetTrailing([]byte(params[n-1]))
+	}
+	return msg
+}
+
+// match reports whether s matches mask with '*' and '?' wildcards.
+func match(mask, s string) bool {
+	mask, s = irc.RFC1459.FoldString(mask), irc.RFC1459.FoldString(s)
+	mi, si, star, mark := 0, 0, -1, 0
+	for si < len(s) {
+		switch {
+		case mi < len(mask) && (mask[mi] == '?' || mask[mi] == s[si]):
+			mi++
+			si++
+		case mi < len(mask) && mask[mi] == '*':
+			star, mark = mi, si
+			mi++
+		case star >= 0:
+			mi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for mi < len(mask) && mask[mi] == '*' {
+		mi++
+	}
+	return mi == len(mask)
+}
+
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > nickLen {
+		return false
+	}
+	for i := 0; i < len(nick); i++ {
+		c := nick[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case strings.IndexByte("[]\\`_^{|}", c) >= 0:
+		case i > 0 && ('0' <= c && c <= '9' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validChannel(name string) bool {
+	if len(name) < 2 || len(name) > channelLen || (name[0] != '#' && name[0] != '&') {
+		return false
+	}
+	return strings.IndexAny(name, " ,\x07") < 0
+}
+
+func isChannel(target string) bool {
+	return target != "" && (target[0] == '#' || target[0] == '&')
+}
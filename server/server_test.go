@@ -0,0 +1,403 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	dec  *irc.Decoder
+}
+
+func dial(t *testing.T, s *Server) *testConn {
+	a, b := net.Pipe()
+	go s.ServeConn(a)
+	t.Cleanup(func() { b.Close() })
+	return &testConn{t, b, irc.NewDecoder(b)}
+}
+
+func (c *testConn) send(lines ...string) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	for _, l := range lines {
+		if _, err := c.conn.Write([]byte(l + "\r\n")); err != nil {
+			c.t.Fatal(l, err)
+		}
+	}
+}
+
+// next returns the next msg as prefix and "CMD param... trailing".
+func (c *testConn) next() (prefix, line string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	msg := new(irc.Msg)
+	if err := c.dec.Decode(msg); err != nil {
+		c.t.Fatal(err)
+	}
+	parts := []string{string(msg.Cmd())}
+	for _, p := range msg.Params() {
+		parts = append(parts, string(p))
+	}
+	if t := msg.Trailing(); t != nil {
+		parts = append(parts, string(t))
+	}
+	return string(msg.Name()), strings.Join(parts, " ")
+}
+
+func (c *testConn) expect(want ...string) {
+	c.t.Helper()
+	for _, w := range want {
+		if _, line := c.next(); line != w {
+			c.t.Fatalf("got %q want %q", line, w)
+		}
+	}
+}
+
+// skip reads until a msg of cmd.
+func (c *testConn) skip(cmd string) string {
+	c.t.Helper()
+	for {
+		_, line := c.next()
+		if strings.HasPrefix(line, cmd+" ") {
+			return line
+		}
+	}
+}
+
+func (c *testConn) register(nick string) {
+	c.t.Helper()
+	c.send("NICK "+nick, "USER "+nick+" 0 * :"+nick+" real")
+	c.skip(irc.ERR_NOMOTD)
+}
+
+func TestRegister(t *testing.T) {
+	s := NewServer("irc.test")
+	s.Network = "TestNet"
+	c := dial(t, s)
+	c.send("JOIN #go", "NICK 1bad", "NICK alice", "USER alice 0 * :Alice A")
+	c.expect(
+		"451 * You have not registered",
+		"432 * 1bad Erroneous nickname",
+		"001 alice Welcome to the TestNet IRC Network alice!alice@pipe",
+		"002 alice Your host is irc.test, running version "+version,
+	)
+	c.skip(irc.RPL_MYINFO)
+	line := c.skip(irc.RPL_ISUPPORT)
+	if !strings.Contains(line, "CASEMAPPING=rfc1459") || !strings.Contains(line, "NETWORK=TestNet") {
+		t.Error(line)
+	}
+	c.expect("422 alice MOTD File is missing")
+
+	c.send("USER a 0 * :a", "PING :tok", "FOO")
+	c.expect(
+		"462 alice You may not reregister",
+		"PONG irc.test tok",
+		"421 alice FOO Unknown command",
+	)
+
+	c2 := dial(t, s)
+	c2.send("NICK ALICE")
+	c2.expect("433 * ALICE Nickname is already in use")
+
+	c.send("QUIT :bye")
+	c.expect("ERROR Closing Link: pipe (Quit: bye)")
+}
+
+func TestRegisterCap(t *testing.T) {
+	s := NewServer("irc.test")
+	s.MOTD = []string{"hello"}
+	c := dial(t, s)
+	c.send("CAP LS 302", "NICK bob", "USER bob 0 * bob", "CAP REQ :sasl")
+	c.expect("CAP * LS server-time", "CAP bob NAK sasl")
+	c.send("CAP END")
+	c.skip(irc.RPL_WELCOME)
+	c.skip(irc.RPL_MOTDSTART)
+	c.expect("372 bob - hello", "376 bob End of /MOTD command.")
+}
+
+func TestServerTimeCap(t *testing.T) {
+	s := NewServer("irc.test")
+	s.Clock = fixedClock(time.Unix(1500000000, 0))
+	c := dial(t, s)
+	c.send("CAP REQ :server-time sasl", "CAP LIST", "CAP REQ :server-time", "CAP LIST")
+	c.expect("CAP * NAK server-time sasl", "CAP * LIST ", "CAP * ACK server-time")
+
+	msg := new(irc.Msg)
+	if err := c.dec.Decode(msg); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := msg.Tag([]byte("time")); string(v) != "2017-07-14T02:40:00.000Z" {
+		t.Errorf("%q", msg.Data)
+	}
+	if string(msg.Params()[1]) != "LIST" || string(msg.Trailing()) != "server-time" {
+		t.Errorf("%q", msg.Data)
+	}
+
+	c.send("CAP REQ :-server-time", "CAP END", "NICK bob", "USER bob 0 * bob")
+	c.expect("CAP * ACK -server-time")
+	c.dec.Decode(msg)
+	if _, ok := msg.Tag([]byte("time")); ok {
+		t.Errorf("%q", msg.Data)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := NewServer("irc.test")
+	s.Auth = AuthFunc(func(c *Client, password string) error {
+		if c.Nick() != "admin" || password != "secret" {
+			return errors.New("denied")
+		}
+		return nil
+	})
+
+	c := dial(t, s)
+	c.send("PASS wrong", "NICK admin", "USER a 0 * a")
+	c.expect("464 admin Password incorrect", "ERROR Closing Link: pipe (Bad password)")
+
+	c = dial(t, s)
+	c.send("PASS secret", "NICK admin", "USER a 0 * a")
+	c.skip(irc.RPL_WELCOME)
+}
+
+func TestChannel(t *testing.T) {
+	s := NewServer("irc.test")
+	alice, bob := dial(t, s), dial(t, s)
+	alice.register("alice")
+	bob.register("bob")
+
+	alice.send("JOIN #Go")
+	alice.expect(
+		"JOIN #Go",
+		"353 alice = #Go @alice",
+		"366 alice #Go End of /NAMES list.",
+	)
+	alice.send("TOPIC #go :all about go")
+	alice.expect("TOPIC #Go all about go")
+
+	bob.send("JOIN #GO")
+	bob.expect("JOIN #Go", "332 bob #Go all about go")
+	if line := bob.skip(irc.RPL_TOPICWHOTIME); !strings.HasPrefix(line, "333 bob #Go alice ") {
+		t.Error(line)
+	}
+	bob.expect("353 bob = #Go @alice bob", "366 bob #Go End of /NAMES list.")
+	if prefix, line := alice.next(); prefix != "bob" || line != "JOIN #Go" {
+		t.Error(prefix, line)
+	}
+
+	bob.send("PRIVMSG #go :hi all", "TOPIC #go :mine")
+	bob.expect("482 bob #Go You're not channel operator")
+	alice.expect("PRIVMSG #Go hi all")
+
+	alice.send("PRIVMSG bob :\x01VERSION\x01", "NOTICE nobody :x", "PRIVMSG nobody :x")
+	bob.expect("PRIVMSG bob \x01VERSION\x01")
+	alice.expect("401 alice nobody No such nick/channel")
+
+	alice.send("NAMES #go,#none", "LIST")
+	alice.expect(
+		"353 alice = #Go @alice bob",
+		"366 alice #Go End of /NAMES list.",
+		"366 alice #none End of /NAMES list.",
+		"321 alice Channel Users  Name",
+		"322 alice #Go 2 all about go",
+		"323 alice End of /LIST",
+	)
+
+	bob.send("NICK Robert")
+	bob.expect("NICK Robert")
+	alice.expect("NICK Robert")
+
+	alice.send("KICK #go robert :behave")
+	alice.expect("KICK #Go Robert behave")
+	bob.expect("KICK #Go Robert behave")
+
+	alice.send("PART #go :later")
+	alice.expect("PART #Go later")
+	alice.send("TOPIC #go")
+	alice.expect("403 alice #go No such channel")
+}
+
+func TestMode(t *testing.T) {
+	s := NewServer("irc.test")
+	alice, bob := dial(t, s), dial(t, s)
+	alice.register("alice")
+	bob.register("bob")
+
+	alice.send("JOIN #go")
+	alice.skip(irc.RPL_ENDOFNAMES)
+	alice.send("MODE #go", "MODE #go +kl-t key 5", "MODE #go +b bob!*@*", "MODE #go b")
+	alice.expect(
+		"324 alice #go +nt",
+		"MODE #go +kl-t key 5",
+		"MODE #go +b bob!*@*",
+		"367 alice #go bob!*@*",
+		"368 alice #go End of channel ban list",
+	)
+	alice.send("MODE #go +z", "MODE #go +o")
+	alice.expect("472 alice z is unknown mode char to me", "461 alice MODE Not enough parameters")
+
+	bob.send("JOIN #go", "JOIN #go key", "MODE #go -b bob!*@*")
+	bob.expect(
+		"475 bob #go Cannot join channel (+k)",
+		"474 bob #go Cannot join channel (+b)",
+		"482 bob #go You're not channel operator",
+	)
+
+	alice.send("MODE #go -b+i BOB!*@*", "INVITE bob #go")
+	alice.expect("MODE #go -b+i bob!*@*", "341 alice bob #go")
+	bob.expect("INVITE bob #go")
+	bob.send("JOIN #go key")
+	bob.skip(irc.RPL_ENDOFNAMES)
+	alice.expect("JOIN #go")
+
+	alice.send("MODE #go +mv bob", "MODE #go")
+	alice.expect("MODE #go +mv bob", "324 alice #go +imnkl key 5")
+	bob.expect("MODE #go +mv bob")
+	bob.send("MODE #go", "PRIVMSG #go :voiced", "MODE #go -v bob")
+	bob.expect("324 bob #go +imnkl key 5")
+	alice.expect("PRIVMSG #go voiced")
+	bob.expect("482 bob #go You're not channel operator")
+
+	alice.send("MODE alice", "MODE alice +ix", "MODE bob +i")
+	alice.expect(
+		"221 alice +",
+		"MODE alice +i",
+		"501 alice Unknown MODE flag",
+		"502 alice Can't change mode for other users",
+	)
+}
+
+func TestWho(t *testing.T) {
+	s := NewServer("irc.test")
+	s.Clock = fixedClock(time.Unix(1500000000, 0))
+	alice, bob := dial(t, s), dial(t, s)
+	alice.register("alice")
+	bob.register("bob")
+	alice.send("JOIN #go")
+	alice.skip(irc.RPL_ENDOFNAMES)
+	bob.send("JOIN #go")
+	bob.skip(irc.RPL_ENDOFNAMES)
+	alice.skip(irc.JOIN)
+
+	alice.send("WHO #go", "WHO b*")
+	alice.expect(
+		"352 alice #go alice pipe irc.test alice H@ 0 alice real",
+		"352 alice #go bob pipe irc.test bob H 0 bob real",
+		"315 alice #go End of WHO list",
+		"352 alice * bob pipe irc.test bob H 0 bob real",
+		"315 alice b* End of WHO list",
+	)
+
+	alice.send("WHOIS bob,nobody")
+	alice.expect(
+		"311 alice bob bob pipe * bob real",
+		"319 alice bob #go",
+		"312 alice bob irc.test ",
+		"317 alice bob 0 1500000000 seconds idle, signon time",
+		"401 alice nobody No such nick/channel",
+		"318 alice bob,nobody End of /WHOIS list.",
+	)
+
+	bob.send("QUIT :gone")
+	alice.expect("QUIT Quit: gone")
+	if nicks := s.Clients(); len(nicks) != 1 || nicks[0] != "alice" {
+		t.Error(nicks)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewServer("irc.test")
+	s.Store = NewMemStore()
+	alice := dial(t, s)
+	alice.register("alice")
+	alice.send("JOIN #go", "TOPIC #go :kept", "MODE #go +s", "PART #go")
+	alice.skip(irc.PART)
+
+	rec, ok := s.Store.LoadChannel("#GO")
+	if !ok || rec.Topic != "kept" || rec.Modes != "nst" {
+		t.Fatal(rec, ok)
+	}
+	alice.send("JOIN #go")
+	alice.expect("JOIN #go", "332 alice #go kept")
+	alice.skip(irc.RPL_TOPICWHOTIME)
+	alice.expect("353 alice @ #go @alice")
+}
+
+func TestClientRegister(t *testing.T) {
+	s := NewServer("irc.test")
+	a, b := net.Pipe()
+	go s.ServeConn(a)
+	defer b.Close()
+
+	c := irc.NewClient(b, &irc.Config{Nick: "gopher", Caps: []string{"echo-message"}})
+	if err := c.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Nick() != "gopher" {
+		t.Error(c.Nick())
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := NewServer("irc.test")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testConn{t, conn, irc.NewDecoder(conn)}
+	c.register("tcp")
+
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Error(err)
+	}
+	msg := new(irc.Msg)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for c.dec.Decode(msg) == nil {
+	}
+	if err := s.Serve(l); err != ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		mask, s string
+		ok      bool
+	}{
+		{"*", "", true},
+		{"a*c", "abbbc", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*!*@host", "Nick[1]!u@HOST", true},
+		{"nick{1}*", "NICK[1]!u@h", true},
+		{"*b", "abc", false},
+	} {
+		if match(c.mask, c.s) != c.ok {
+			t.Error(c.mask, c.s)
+		}
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
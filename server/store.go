@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mengzhuo/irc"
+)
+
+// ChannelRecord is the state of a channel kept by Store.
+type ChannelRecord struct {
+	Name      string
+	Topic     string
+	TopicBy   string
+	TopicTime time.Time
+	// Modes are modes without argument, e.g. "nt".
+	Modes string
+	Key   string
+	Limit int
+	Bans  []string
+}
+
+// Store keeps channels between their lives. A channel is loaded when it is
+// created by the first JOIN and saved when its topic or modes change and
+// when it is emptied.
+// Methods are called with the server locked and must not block long.
+type Store interface {
+	LoadChannel(name string) (rec ChannelRecord, ok bool)
+	SaveChannel(rec ChannelRecord)
+}
+
+// MemStore is a Store in memory.
+type MemStore struct {
+	mu       sync.Mutex
+	channels *irc.FoldMap[ChannelRecord]
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{channels: irc.NewFoldMap[ChannelRecord](irc.RFC1459)}
+}
+
+func (m *MemStore) LoadChannel(name string) (rec ChannelRecord, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.channels.Get(name)
+}
+
+func (m *MemStore) SaveChannel(rec ChannelRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channels.Set(rec.Name, rec)
+}
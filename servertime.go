@@ -0,0 +1,51 @@
+package irc
+
+import "time"
+
+// ServerTimeFormat is the layout of the IRCv3 time tag, see
+// https://ircv3.net/specs/extensions/server-time
+const ServerTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var timeTag = []byte("time")
+
+// AppendServerTime appends t in UTC formatted by ServerTimeFormat to dst
+// and returns the extended buffer.
+func AppendServerTime(dst []byte, t time.Time) []byte {
+	return t.UTC().AppendFormat(dst, ServerTimeFormat)
+}
+
+// ServerTime returns the time tag, ok is false if it is missing or
+// malformed.
+func (m *Msg) ServerTime() (t time.Time, ok bool) {
+	v, ok := m.Tag(timeTag)
+	if !ok {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(v))
+	return t, err == nil
+}
+
+// SetServerTime sets the time tag to t.
+func (m *Msg) SetServerTime(t time.Time) {
+	var buf [len(ServerTimeFormat)]byte
+	m.SetTag(timeTag, AppendServerTime(buf[:0], t))
+}
+
+// Received returns when msg was decoded, zero if the Decoder had no Clock.
+func (m *Msg) Received() time.Time {
+	return m.received
+}
+
+// SetReceived sets the receive time of msg.
+func (m *Msg) SetReceived(t time.Time) {
+	m.received = t
+}
+
+// Time returns the server time of msg, or the receive time if the server
+// sent none, zero if neither is known.
+func (m *Msg) Time() time.Time {
+	if t, ok := m.ServerTime(); ok {
+		return t
+	}
+	return m.received
+}
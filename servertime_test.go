@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTime(t *testing.T) {
+	recv := time.Unix(1500000000, 0)
+	m := newTestMsg("@time=2011-10-19T16:40:51.620Z :nick!u@h PRIVMSG #chan :hi")
+	m.SetReceived(recv)
+	want := time.Date(2011, 10, 19, 16, 40, 51, 620e6, time.UTC)
+	if st, ok := m.ServerTime(); !ok || !st.Equal(want) {
+		t.Error(st, ok)
+	}
+	if !m.Time().Equal(want) {
+		t.Error(m.Time())
+	}
+
+	for _, raw := range []string{"@time=yesterday PING", "PING"} {
+		m := newTestMsg(raw)
+		m.SetReceived(recv)
+		if _, ok := m.ServerTime(); ok || !m.Time().Equal(recv) {
+			t.Error(raw, m.Time())
+		}
+	}
+
+	m = newTestMsg("@msgid=1 PING")
+	m.SetServerTime(time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.FixedZone("", 3600)))
+	if v, _ := m.Tag([]byte("time")); string(v) != "2020-01-02T02:04:05.006Z" {
+		t.Error(string(v))
+	}
+}
+
+func TestDecodeReceived(t *testing.T) {
+	d := NewDecoder(strings.NewReader("PING a\r\nPING b\r\n"))
+	m := new(Msg)
+	if err := d.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Received().IsZero() {
+		t.Error("received without Clock", m.Received())
+	}
+
+	clock := newFakeClock()
+	d.Clock = clock
+	if err := d.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Received().Equal(clock.Now()) {
+		t.Error(m.Received())
+	}
+	if m.Clone().Received() != m.Received() {
+		t.Error("clone")
+	}
+	if m.Reset(); !m.Received().IsZero() {
+		t.Error("reset")
+	}
+}
+
+func TestEncodeServerTime(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.ServerTime = newFakeClock()
+	for _, raw := range []string{
+		"PING a",
+		"@msgid=1 :srv NOTICE * :hi",
+		"@time=2011-10-19T16:40:51.620Z PING b",
+	} {
+		m := newTestMsg(raw)
+		if _, err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Data) != raw {
+			t.Error("msg changed", string(m.Data))
+		}
+	}
+	now := string(AppendServerTime(nil, e.ServerTime.Now()))
+	want := "@time=" + now + " PING a\r\n" +
+		"@time=" + now + ";msgid=1 :srv NOTICE * :hi\r\n" +
+		"@time=2011-10-19T16:40:51.620Z PING b\r\n"
+	if buf.String() != want {
+		t.Errorf("got %q want %q", buf.String(), want)
+	}
+}
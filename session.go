@@ -0,0 +1,376 @@
+package irc
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionState is a lifecycle state of Session.
+type SessionState int
+
+const (
+	Disconnected SessionState = iota
+	Reconnecting
+	Registered
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case Disconnected:
+		return "Disconnected"
+	case Reconnecting:
+		return "Reconnecting"
+	case Registered:
+		return "Registered"
+	}
+	return "SessionState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// SessionEvent is emitted to subscribers of Session on state change.
+type SessionEvent struct {
+	State   SessionState
+	Err     error         // cause of Disconnected
+	Attempt int           // attempt of Reconnecting, from 1
+	Delay   time.Duration // backoff of Reconnecting before dialing
+}
+
+// Session keeps a client connected. It redials with jittered exponential
+// backoff when the connection fails, registers again with Config including
+// CAP and SASL, and rejoins channels joined before with their keys.
+type Session struct {
+	Addr   string
+	Config *Config
+
+	// Dial connects to addr, default dials TCP. Use Dialer.DialContext for
+	// TLS.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	// Handler is called for every msg of every connection, may be nil.
+	Handler Handler
+
+	// Backoff before the n-th redial is MinBackoff*2^(n-1) capped at
+	// MaxBackoff, randomized to [d/2, d].
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// PING is sent every PingInterval by a Keepalive, the connection fails
+	// with *TimeoutError if nothing is received for two intervals.
+	// Negative disables.
+	PingInterval time.Duration
+
+	Clock Clock
+
+	mu       sync.Mutex
+	client   *Client
+	channels *FoldMap[string] // name to key
+	subs     []chan SessionEvent
+}
+
+// NewSession returns a session to addr with defaults, call Run to connect.
+func NewSession(addr string, cfg *Config) *Session {
+	return &Session{
+		Addr:         addr,
+		Config:       cfg,
+		MinBackoff:   time.Second,
+		MaxBackoff:   5 * time.Minute,
+		PingInterval: 2 * time.Minute,
+		Clock:        SystemClock,
+		channels:     NewFoldMap[string](RFC1459),
+	}
+}
+
+// Client returns the registered client, nil while disconnected.
+func (s *Session) Client() *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// Channels returns the channels rejoined on reconnect in order.
+func (s *Session) Channels() (names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels.Range(func(name, _ string) bool {
+		names = append(names, name)
+		return true
+	})
+	sort.Strings(names)
+	return
+}
+
+// Join joins channel with optional key, the channel is joined on next
+// registration if disconnected. It is not rejoined once the server refused
+// the JOIN.
+func (s *Session) Join(channel, key string) error {
+	s.mu.Lock()
+	s.channels.Set(channel, key)
+	c := s.client
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	var keys []string
+	if key != "" {
+		keys = []string{key}
+	}
+	msg, err := Join([]string{channel}, keys)
+	if err != nil {
+		return err
+	}
+	_, err = c.Encode(msg)
+	return err
+}
+
+// Part parts channel, it is no longer rejoined.
+func (s *Session) Part(channel, reason string) error {
+	s.mu.Lock()
+	s.channels.Delete(channel)
+	c := s.client
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	msg, err := Part([]string{channel}, reason)
+	if err != nil {
+		return err
+	}
+	_, err = c.Encode(msg)
+	return err
+}
+
+// Subscribe returns a channel of session events buffered by n. Events are
+// dropped for a subscriber whose buffer is full.
+func (s *Session) Subscribe(n int) <-chan SessionEvent {
+	c := make(chan SessionEvent, n)
+	s.mu.Lock()
+	s.subs = append(s.subs, c)
+	s.mu.Unlock()
+	return c
+}
+
+// Unsubscribe stops and closes c returned by Subscribe.
+func (s *Session) Unsubscribe(c <-chan SessionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subs {
+		if sub == c {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+func (s *Session) emit(e SessionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// Run connects and reconnects until ctx is done, it returns ctx.Err().
+func (s *Session) Run(ctx context.Context) error {
+	attempt := 0
+	for {
+		registered, err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if registered {
+			attempt = 0
+		}
+		s.emit(SessionEvent{State: Disconnected, Err: err})
+
+		attempt++
+		delay := s.backoff(attempt)
+		wait := s.Clock.After(delay)
+		s.emit(SessionEvent{State: Reconnecting, Attempt: attempt, Delay: delay})
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns the jittered delay before n-th redial.
+func (s *Session) backoff(n int) time.Duration {
+	d := s.MinBackoff
+	for i := 1; i < n && d < s.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.MaxBackoff {
+		d = s.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// connect runs one connection until it fails.
+func (s *Session) connect(ctx context.Context) (registered bool, err error) {
+	dial := s.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	conn, err := dial(ctx, s.Addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c := NewClient(conn, s.Config)
+	c.Handler = HandlerFunc(func(w Writer, msg *Msg) {
+		s.track(c, msg)
+		if s.Handler != nil {
+			s.Handler.ServeIRC(w, msg)
+		}
+	})
+	if s.PingInterval >= 0 {
+		interval := s.PingInterval
+		if interval == 0 {
+			interval = 2 * time.Minute
+		}
+		c.Keepalive = NewKeepalive(interval, 2*interval)
+		c.Keepalive.Clock = s.Clock
+		kctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go c.Keepalive.Run(kctx, c, conn)
+	}
+
+	if err = c.Register(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.client = c
+	s.mu.Unlock()
+	s.emit(SessionEvent{State: Registered})
+
+	if err = s.rejoin(c); err == nil {
+		err = c.Run()
+	}
+	s.mu.Lock()
+	s.client = nil
+	s.mu.Unlock()
+	return true, err
+}
+
+// track records channels joined and parted by c.
+func (s *Session) track(c *Client, msg *Msg) {
+	arg := msg.arg
+	me := c.Nick()
+	cm := c.ISupport().CaseMap()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch string(msg.Cmd()) {
+	case RPL_ISUPPORT:
+		s.channels.SetCaseMapping(cm)
+	case JOIN:
+		if cm.EqualString(string(msg.Name()), me) {
+			for _, name := range strings.Split(arg(0), ",") {
+				key, _ := s.channels.Get(name)
+				s.channels.Set(name, key)
+			}
+		}
+	case PART:
+		if cm.EqualString(string(msg.Name()), me) {
+			for _, name := range strings.Split(arg(0), ",") {
+				s.channels.Delete(name)
+			}
+		}
+	case KICK:
+		if cm.EqualString(arg(1), me) {
+			s.channels.Delete(arg(0))
+		}
+	case ERR_NOSUCHCHANNEL, ERR_TOOMANYCHANNELS, ERR_CHANNELISFULL, ERR_INVITEONLYCHAN,
+		ERR_BANNEDFROMCHAN, ERR_BADCHANNELKEY, ERR_BADCHANMASK:
+		// me channel :reason, JOIN refused
+		s.channels.Delete(arg(1))
+	case MODE:
+		target, changes, err := ParseModeMsg(c.ISupport(), msg)
+		if err != nil {
+			return
+		}
+		if _, ok := s.channels.Get(target); !ok {
+			return
+		}
+		for _, ch := range changes {
+			if ch.Mode != 'k' {
+				continue
+			}
+			if ch.Add && ch.Arg != "" && ch.Arg != "*" {
+				s.channels.Set(target, ch.Arg)
+			} else if !ch.Add {
+				s.channels.Set(target, "")
+			}
+		}
+	}
+}
+
+// rejoin joins the recorded channels, keyed channels first as JOIN
+// requires.
+func (s *Session) rejoin(c *Client) (err error) {
+	type entry struct{ name, key string }
+	var keyed, plain []entry
+	s.mu.Lock()
+	s.channels.Range(func(name, key string) bool {
+		if key != "" {
+			keyed = append(keyed, entry{name, key})
+		} else {
+			plain = append(plain, entry{name, ""})
+		}
+		return true
+	})
+	s.mu.Unlock()
+	for _, l := range [][]entry{keyed, plain} {
+		sort.Slice(l, func(i, j int) bool { return l[i].name < l[j].name })
+	}
+
+	var channels, keys []string
+	size := 0
+	flush := func() error {
+		if len(channels) == 0 {
+			return nil
+		}
+		msg, err := Join(channels, keys)
+		if err != nil {
+			return err
+		}
+		channels, keys, size = nil, nil, 0
+		_, err = c.Encode(msg)
+		return err
+	}
+	for _, e := range append(keyed, plain...) {
+		if size+len(e.name)+len(e.key)+2 > maxJoinLen {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+		channels = append(channels, e.name)
+		if e.key != "" {
+			keys = append(keys, e.key)
+		}
+		size += len(e.name) + len(e.key) + 2
+	}
+	return flush()
+}
+
+// maxJoinLen limits channels and keys of one JOIN.
+const maxJoinLen = 400
@@ -0,0 +1,227 @@
+package irc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan SessionEvent, state SessionState) SessionEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.State != state {
+			t.Fatalf("got %v %v, want %v", e.State, e.Err, state)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event", state)
+	}
+	return SessionEvent{}
+}
+
+func TestSessionReconnect(t *testing.T) {
+	clock := newFakeClock()
+	servers := make(chan *fakeServer, 1)
+	s := NewSession("irc.example:6667", &Config{Nick: "bot"})
+	s.Clock = clock
+	s.PingInterval = time.Minute
+	dials := 0
+	s.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		dials++
+		if dials == 2 {
+			return nil, errors.New("connection refused")
+		}
+		srv, conn := newFakeServer(t)
+		servers <- srv
+		return conn, nil
+	}
+	events := s.Subscribe(10)
+	s.Join("#go", "secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	register := func(srv *fakeServer) {
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv 001 bot :Welcome")
+	}
+
+	srv := <-servers
+	register(srv)
+	nextEvent(t, events, Registered)
+	srv.expect("JOIN #go secret")
+	srv.send(":bot!u@h JOIN #go", ":bot!u@h JOIN :#irc", ":bot!u@h JOIN #tmp")
+	srv.send(":op!u@h MODE #irc +k other")
+	srv.send(":bot!u@h PART #tmp :bye")
+	srv.conn.Close()
+
+	if e := nextEvent(t, events, Disconnected); e.Err == nil {
+		t.Error("no cause")
+	}
+	e := nextEvent(t, events, Reconnecting)
+	if e.Attempt != 1 || e.Delay < 500*time.Millisecond || e.Delay > time.Second {
+		t.Error(e)
+	}
+	clock.Advance(e.Delay)
+
+	// dial fails, backoff grows
+	nextEvent(t, events, Disconnected)
+	e = nextEvent(t, events, Reconnecting)
+	if e.Attempt != 2 || e.Delay < time.Second || e.Delay > 2*time.Second {
+		t.Error(e)
+	}
+	clock.Advance(e.Delay)
+
+	srv = <-servers
+	register(srv)
+	nextEvent(t, events, Registered)
+	srv.expect("JOIN #go,#irc secret,other")
+	if got := strings.Join(s.Channels(), " "); got != "#go #irc" {
+		t.Error(got)
+	}
+
+	// dead link: PING every interval, closed after two without traffic,
+	// the first keepalive left a stale waiter
+	clock.Wait(2)
+	clock.Advance(time.Minute)
+	if line, _ := srv.rdr.ReadString('\n'); line != "PING :1\r\n" {
+		t.Errorf("got %q", line)
+	}
+	clock.Wait(1)
+	clock.Advance(time.Minute)
+	var te *TimeoutError
+	if e := nextEvent(t, events, Disconnected); !errors.As(e.Err, &te) || te.Idle != 2*time.Minute {
+		t.Error(e.Err)
+	}
+	nextEvent(t, events, Reconnecting)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error(err)
+	}
+	s.Unsubscribe(events)
+	if _, ok := <-events; ok {
+		t.Error("events not closed")
+	}
+}
+
+func TestSessionJoinRefused(t *testing.T) {
+	servers := make(chan *fakeServer, 1)
+	s := NewSession("irc.example:6667", &Config{Nick: "bot"})
+	s.Clock = newFakeClock()
+	s.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		srv, conn := newFakeServer(t)
+		servers <- srv
+		return conn, nil
+	}
+	events := s.Subscribe(10)
+	s.Join("#go", "")
+	s.Join("#closed", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	srv := <-servers
+	srv.expect("NICK bot")
+	srv.expect("USER bot 0 * bot")
+	srv.send(":srv 001 bot :Welcome")
+	nextEvent(t, events, Registered)
+	srv.expect("JOIN #closed,#go")
+	go s.Join("#banned", "") // net.Pipe blocks until read
+	srv.expect("JOIN #banned")
+	srv.send(
+		":srv 473 bot #closed :Cannot join channel (+i)",
+		":bot!u@h JOIN #go",
+		":srv 474 bot #banned :Cannot join channel (+b)",
+		"PING :sync",
+	)
+	srv.expect("PONG :sync")
+	if got := strings.Join(s.Channels(), " "); got != "#go" {
+		t.Error(got)
+	}
+
+	cancel()
+	srv.conn.Close()
+	if err := <-done; err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestSessionSCRAM(t *testing.T) {
+	clock := newFakeClock()
+	servers := make(chan *fakeServer, 1)
+	s := NewSession("irc.example:6667", &Config{Nick: "bot",
+		SASL: &ScramSHA256{User: "user", Password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}})
+	s.Clock = clock
+	s.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		srv, conn := newFakeServer(t)
+		servers <- srv
+		return conn, nil
+	}
+	events := s.Subscribe(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	// RFC 7677 section 3
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	for i := 0; i < 2; i++ {
+		srv := <-servers
+		srv.expect("CAP LS 302")
+		srv.expect("NICK bot")
+		srv.expect("USER bot 0 * bot")
+		srv.send(":srv CAP * LS :sasl=SCRAM-SHA-256")
+		srv.expect("CAP REQ :sasl")
+		srv.send(":srv CAP * ACK :sasl")
+		srv.expect("AUTHENTICATE SCRAM-SHA-256")
+		srv.send("AUTHENTICATE +")
+		srv.expect("AUTHENTICATE " + b64("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		srv.send("AUTHENTICATE " + b64("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+		srv.expect("AUTHENTICATE " + b64("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+		srv.send("AUTHENTICATE " + b64("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+		srv.expect("AUTHENTICATE +")
+		srv.send(":srv 903 bot :SASL authentication successful")
+		srv.expect("CAP END")
+		srv.send(":srv 001 bot :Welcome")
+		nextEvent(t, events, Registered)
+
+		srv.conn.Close()
+		nextEvent(t, events, Disconnected)
+		clock.Advance(nextEvent(t, events, Reconnecting).Delay)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestSessionBackoff(t *testing.T) {
+	s := NewSession("", &Config{Nick: "bot"})
+	s.MinBackoff, s.MaxBackoff = time.Second, 10*time.Second
+	for _, c := range []struct {
+		n   int
+		max time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := s.backoff(c.n); d < c.max/2 || d > c.max {
+				t.Errorf("backoff(%d) = %v, want [%v, %v]", c.n, d, c.max/2, c.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package irc
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	// MaxLineLen is the max length of a line without tags, including CRLF.
+	MaxLineLen = 512
+
+	// MultilineBatch is the IRCv3 draft/multiline batch type.
+	MultilineBatch = "draft/multiline"
+	// MultilineConcatTag marks a line that continues the previous one
+	// without line break in a multiline batch.
+	MultilineConcatTag = "draft/multiline-concat"
+	// BatchTag refers a msg to its batch.
+	BatchTag = "batch"
+)
+
+var batchRef uint32
+
+// TrailingLen returns the max length of trailing of msg so that the line
+// relayed by server with a prefix of prefixLen (like "nick!user@host") fits
+// in MaxLineLen.
+func TrailingLen(msg *Msg, prefixLen int) int {
+	n := MaxLineLen - 2 - len(msg.cmd) - 2 // CRLF, cmd and " :"
+	if prefixLen > 0 {
+		n -= prefixLen + 2 // ':' prefix ' '
+	}
+	for _, p := range msg.Params() {
+		n -= len(p) + 1
+	}
+	return n
+}
+
+// SplitText returns the length of the first chunk of text that fits in max
+// bytes and the offset the next chunk starts at.
+// Text is split after the last space, or at a rune boundary if there is no
+// space, never inside a multibyte rune or a colour code. The space is kept
+// in the chunk if keepSpace is true, otherwise dropped. Line breaks always
+// end a chunk and are dropped.
+func SplitText(text []byte, max int, keepSpace bool) (end, next int) {
+	lastSpace := -1
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		if c == '\r' || c == '\n' {
+			next = i + 1
+			if c == '\r' && next < len(text) && text[next] == '\n' {
+				next++
+			}
+			return i, next
+		}
+
+		size := formatLen(text[i:])
+		if i+size > max {
+			break
+		}
+		if c == space {
+			lastSpace = i
+		}
+		i += size
+	}
+
+	if i == len(text) {
+		return i, i
+	}
+	if lastSpace > 0 {
+		if keepSpace {
+			return lastSpace + 1, lastSpace + 1
+		}
+		return lastSpace, lastSpace + 1
+	}
+	if i == 0 {
+		// max is too small for a single token, cut it anyway
+		i = formatLen(text)
+	}
+	return i, i
+}
+
+// formatLen returns the length of the rune or formatting code at the
+// beginning of b.
+func formatLen(b []byte) int {
+	switch b[0] {
+	case colorCode, hexColorCode:
+		n, _, _, _ := parseColor(b)
+		return n
+	}
+	_, size := utf8.DecodeRune(b)
+	return size
+}
+
+func digits(b []byte, max int, valid func(byte) bool) (n int) {
+	for n < max && n < len(b) && valid(b[n]) {
+		n++
+	}
+	return
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// EncodeSplit encodes msg as several msgs with the trailing split by
+// SplitText so that each line relayed by server with a prefix of prefixLen
+// fits in MaxLineLen. Lines are written at once.
+func (e *Encoder) EncodeSplit(msg *Msg, prefixLen int) (n int, err error) {
+	max := TrailingLen(msg, prefixLen)
+	if max <= 0 {
+		return 0, errors.New("no space for trailing")
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.buf = e.buf[:0]
+	part := *msg
+	part.tagBuf = nil
+	text := msg.Trailing()
+	for {
+		end, next := SplitText(text, max, false)
+		// skip blank lines
+		if end != 0 || next == len(text) {
+			part.trailing = text[:end]
+			if err = e.encode(&part); err != nil {
+				return
+			}
+		}
+		text = text[next:]
+		if len(text) == 0 {
+			break
+		}
+	}
+	return e.w.Write(e.buf)
+}
+
+// EncodeMultiline encodes msg as an IRCv3 draft/multiline batch to the
+// first param of msg, long lines are split like EncodeSplit and continued
+// with the draft/multiline-concat tag. A ref is generated if ref is empty.
+// The server must have acknowledged the draft/multiline cap and the caller
+// is responsible for its max-bytes and max-lines limits.
+func (e *Encoder) EncodeMultiline(msg *Msg, prefixLen int, ref string) (n int, err error) {
+	params := msg.Params()
+	if len(params) == 0 {
+		return 0, errors.New("no target")
+	}
+	if ref == "" {
+		ref = strconv.FormatUint(uint64(atomic.AddUint32(&batchRef, 1)), 36)
+	}
+
+	part := *msg
+	part.tagBuf = nil
+	part.SetTag([]byte(BatchTag), []byte(ref))
+	concat := part
+	concat.tagBuf = nil
+	concat.SetTag([]byte(MultilineConcatTag), nil)
+
+	max := TrailingLen(msg, prefixLen)
+	if max <= 0 {
+		return 0, errors.New("no space for trailing")
+	}
+
+	batch := new(Msg)
+	batch.SetCmd([]byte(BATCH))
+	batch.SetParams([]byte("+"+ref), []byte(MultilineBatch), params[0])
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.buf = e.buf[:0]
+	e.encode(batch)
+
+	text := msg.Trailing()
+	line := &part
+	for {
+		end, next := SplitText(text, max, true)
+		line.trailing = text[:end]
+		if err = e.encode(line); err != nil {
+			return
+		}
+
+		// a split inside a line is continued, a line break starts a new line
+		line = &part
+		if next == end {
+			line = &concat
+		}
+		text = text[next:]
+		if len(text) == 0 {
+			break
+		}
+	}
+
+	batch.SetParams([]byte("-" + ref))
+	e.encode(batch)
+	return e.w.Write(e.buf)
+}
@@ -0,0 +1,115 @@
+package irc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	for _, z := range []struct {
+		text      string
+		max       int
+		keepSpace bool
+		end, next int
+	}{
+		{"hello world", 20, false, 11, 11},
+		{"hello world", 8, false, 5, 6},
+		{"hello world", 8, true, 6, 6},
+		{"helloworld", 4, false, 4, 4},
+		{"héllo", 2, false, 1, 1},         // é is 2 bytes
+		{"ab\x0312,04cd", 5, false, 2, 2}, // colour code is not split
+		{"ab\x04ff00ffcd", 6, false, 2, 2},
+		{"line1\r\nline2", 20, false, 5, 7},
+		{"line1\nline2", 20, true, 5, 6},
+		{"\x0312,04", 2, false, 6, 6},
+	} {
+		end, next := SplitText(s2b(z.text), z.max, z.keepSpace)
+		if end != z.end || next != z.next {
+			t.Errorf("%q max=%d: got %d,%d want %d,%d", z.text, z.max, end, next, z.end, z.next)
+		}
+	}
+}
+
+func TestEncodeSplit(t *testing.T) {
+	word := "日本語 "
+	text := strings.Repeat(word, 100)
+	msg := new(Msg)
+	msg.SetCmd(s2b(PRIVMSG))
+	msg.SetParams(s2b("#chan"))
+	msg.SetTrailing(s2b(text))
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	prefix := "nick!user@example.org"
+	if _, err := enc.EncodeSplit(msg, len(prefix)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatal(len(lines))
+	}
+	var got []string
+	for i, l := range lines {
+		if n := len(":"+prefix+" ") + len(l) + 2; n > MaxLineLen {
+			t.Error("line too long", n)
+		}
+		m, err := NewMsg(s2b(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := m.Trailing()
+		if !utf8.Valid(tr) || (i != len(lines)-1 && strings.HasSuffix(string(tr), " ")) {
+			t.Errorf("bad chunk %q", tr)
+		}
+		got = append(got, string(tr))
+	}
+	if strings.Join(got, " ") != text {
+		t.Error("text changed")
+	}
+}
+
+func TestEncodeSplitLines(t *testing.T) {
+	msg := new(Msg)
+	msg.SetCmd(s2b(NOTICE))
+	msg.SetParams(s2b("nick"))
+	msg.SetTrailing(s2b("a\n\nb"))
+	buf := bytes.NewBuffer(nil)
+	NewEncoder(buf).EncodeSplit(msg, 0)
+	if buf.String() != "NOTICE nick :a\r\nNOTICE nick :b\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+}
+
+func TestEncodeMultiline(t *testing.T) {
+	msg := new(Msg)
+	msg.SetCmd(s2b(PRIVMSG))
+	msg.SetParams(s2b("#chan"))
+	msg.SetTrailing(s2b("first line\nsecond " + strings.Repeat("x", 600)))
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := NewEncoder(buf).EncodeMultiline(msg, 0, "ref1"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 6 {
+		t.Fatal(len(lines), lines)
+	}
+	if lines[0] != "BATCH +ref1 draft/multiline #chan" || lines[5] != "BATCH -ref1" {
+		t.Error(lines[0], lines[5])
+	}
+	if lines[1] != "@batch=ref1 PRIVMSG #chan :first line" ||
+		lines[2] != "@batch=ref1 PRIVMSG #chan :second " {
+		t.Error(lines[1], lines[2])
+	}
+	for _, l := range lines[3:5] {
+		if !strings.HasPrefix(l, "@batch=ref1;draft/multiline-concat PRIVMSG #chan :x") {
+			t.Error(l)
+		}
+	}
+	if len(msg.Tags()) != 0 {
+		t.Error("msg modified", string(msg.Tags()))
+	}
+}
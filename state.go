@@ -0,0 +1,432 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChannelInfo is a snapshot of a joined channel.
+type ChannelInfo struct {
+	Name      string
+	Topic     string
+	TopicBy   string
+	TopicTime time.Time
+	// Modes maps mode to its argument, modes without argument map to "".
+	Modes map[byte]string
+	// Members maps nick to membership symbols, highest first, e.g. "@+".
+	Members map[string]string
+}
+
+// UserInfo is a snapshot of a user sharing a channel with us.
+type UserInfo struct {
+	Nick     string
+	User     string
+	Host     string
+	Channels []string
+}
+
+type channel struct {
+	ChannelInfo
+	members *FoldMap[string]
+	names   []NamesMember // NAMES burst until RPL_ENDOFNAMES
+}
+
+type user struct {
+	nick, user, host string
+	channels         *FoldSet
+}
+
+// State tracks channels and users from msgs of a client connection.
+// Nicks and channel names are compared under CASEMAPPING of ISupport.
+// It is safe for concurrent use, queries return copies.
+type State struct {
+	ISupport *ISupport
+	Clock    Clock
+
+	mu       sync.RWMutex
+	me       string
+	channels *FoldMap[*channel]
+	users    *FoldMap[*user]
+}
+
+func NewState() *State {
+	return &State{
+		ISupport: NewISupport(),
+		Clock:    SystemClock,
+		channels: NewFoldMap[*channel](RFC1459),
+		users:    NewFoldMap[*user](RFC1459),
+	}
+}
+
+// Me returns our nick.
+func (s *State) Me() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.me
+}
+
+// Channels returns joined channels in order.
+func (s *State) Channels() (names []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.channels.Range(func(_ string, ch *channel) bool {
+		names = append(names, ch.Name)
+		return true
+	})
+	sort.Strings(names)
+	return
+}
+
+// Channel returns a snapshot of the joined channel name.
+func (s *State) Channel(name string) (c ChannelInfo, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels.Get(name)
+	if !ok {
+		return
+	}
+	c = ch.ChannelInfo
+	c.Modes = make(map[byte]string, len(ch.Modes))
+	for k, v := range ch.Modes {
+		c.Modes[k] = v
+	}
+	c.Members = make(map[string]string, ch.members.Len())
+	ch.members.Range(func(k, v string) bool {
+		c.Members[k] = v
+		return true
+	})
+	return
+}
+
+// User returns a snapshot of the user nick.
+func (s *State) User(nick string) (u UserInfo, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	us, ok := s.users.Get(nick)
+	if !ok {
+		return
+	}
+	u = UserInfo{Nick: us.nick, User: us.user, Host: us.host}
+	us.channels.Range(func(name string) bool {
+		u.Channels = append(u.Channels, name)
+		return true
+	})
+	sort.Strings(u.Channels)
+	return
+}
+
+// Prefix returns the membership symbols of nick in channel.
+func (s *State) Prefix(channel, nick string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if ch, ok := s.channels.Get(channel); ok {
+		prefix, _ := ch.members.Get(nick)
+		return prefix
+	}
+	return ""
+}
+
+// ServeIRC updates state by msg.
+func (s *State) ServeIRC(w Writer, msg *Msg) {
+	s.Update(msg)
+}
+
+// Update state by msg.
+func (s *State) Update(msg *Msg) {
+	arg, trailing := msg.arg, msg.Trailing()
+
+	switch string(msg.Cmd()) {
+	case RPL_WELCOME:
+		s.mu.Lock()
+		s.me = arg(0)
+		s.mu.Unlock()
+
+	case RPL_ISUPPORT:
+		s.ISupport.Parse(msg)
+		s.mu.Lock()
+		s.setCaseMapping(s.ISupport.CaseMap())
+		s.mu.Unlock()
+
+	case JOIN:
+		s.mu.Lock()
+		nick := string(msg.Name())
+		name := arg(0)
+		if s.isMe(nick) {
+			s.channels.Set(name, &channel{
+				ChannelInfo: ChannelInfo{Name: name, Modes: make(map[byte]string)},
+				members:     NewFoldMap[string](s.channels.CaseMapping()),
+			})
+		}
+		prefix := ""
+		if ch, ok := s.channels.Get(name); ok {
+			// a repeated JOIN keeps status
+			prefix, _ = ch.members.Get(nick)
+		}
+		if s.join(name, nick, prefix) {
+			u, _ := s.users.Get(nick)
+			u.user, u.host = string(msg.User()), string(msg.Host())
+		}
+		s.mu.Unlock()
+
+	case PART:
+		s.mu.Lock()
+		s.part(arg(0), string(msg.Name()))
+		s.mu.Unlock()
+
+	case KICK:
+		s.mu.Lock()
+		s.part(arg(0), arg(1))
+		s.mu.Unlock()
+
+	case QUIT:
+		s.mu.Lock()
+		nick := string(msg.Name())
+		if u, ok := s.users.Get(nick); ok {
+			var names []string
+			u.channels.Range(func(name string) bool {
+				names = append(names, name)
+				return true
+			})
+			for _, name := range names {
+				s.part(name, nick)
+			}
+		}
+		s.mu.Unlock()
+
+	case NICK:
+		s.mu.Lock()
+		s.rename(string(msg.Name()), arg(0))
+		s.mu.Unlock()
+
+	case MODE, RPL_CHANNELMODEIS:
+		target, changes, err := ParseModeMsg(s.ISupport, msg)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(target); ok {
+			s.mode(ch, changes)
+		}
+		s.mu.Unlock()
+
+	case RPL_NAMREPLY:
+		r, err := ParseNamesReply(s.ISupport, msg)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(r.Channel); ok {
+			ch.names = append(ch.names, r.Members...)
+		}
+		s.mu.Unlock()
+
+	case RPL_ENDOFNAMES:
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(arg(1)); ok {
+			s.setNames(ch)
+		}
+		s.mu.Unlock()
+
+	case RPL_TOPIC:
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(arg(1)); ok {
+			ch.Topic = string(trailing)
+		}
+		s.mu.Unlock()
+
+	case RPL_NOTOPIC:
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(arg(1)); ok {
+			ch.Topic, ch.TopicBy, ch.TopicTime = "", "", time.Time{}
+		}
+		s.mu.Unlock()
+
+	case RPL_TOPICWHOTIME:
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(arg(1)); ok {
+			ch.TopicBy = arg(2)
+			if sec, err := strconv.ParseInt(arg(3), 10, 64); err == nil {
+				ch.TopicTime = time.Unix(sec, 0)
+			}
+		}
+		s.mu.Unlock()
+
+	case TOPIC:
+		s.mu.Lock()
+		if ch, ok := s.channels.Get(arg(0)); ok {
+			ch.Topic = arg(1)
+			ch.TopicBy = string(msg.Name())
+			ch.TopicTime = s.Clock.Now()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// isMe reports whether nick is our nick.
+func (s *State) isMe(nick string) bool {
+	return s.channels.CaseMapping().EqualString(nick, s.me)
+}
+
+// setCaseMapping re-keys all maps by cm.
+func (s *State) setCaseMapping(cm CaseMapping) {
+	s.channels.SetCaseMapping(cm)
+	s.users.SetCaseMapping(cm)
+	s.channels.Range(func(_ string, ch *channel) bool {
+		ch.members.SetCaseMapping(cm)
+		return true
+	})
+	s.users.Range(func(_ string, u *user) bool {
+		u.channels.SetCaseMapping(cm)
+		return true
+	})
+}
+
+// join adds nick to channel name, it reports whether channel is joined.
+func (s *State) join(name, nick, prefix string) bool {
+	ch, ok := s.channels.Get(name)
+	if !ok || nick == "" {
+		return false
+	}
+	ch.members.Set(nick, prefix)
+	u, ok := s.users.Get(nick)
+	if !ok {
+		u = &user{nick: nick, channels: NewFoldSet(s.users.CaseMapping())}
+		s.users.Set(nick, u)
+	}
+	u.channels.Add(ch.Name)
+	return true
+}
+
+// setNames replaces members of ch by its NAMES burst.
+func (s *State) setNames(ch *channel) {
+	names := NewFoldSet(s.users.CaseMapping())
+	for _, m := range ch.names {
+		names.Add(m.Nick)
+	}
+	var gone []string
+	ch.members.Range(func(member, _ string) bool {
+		if !names.Has(member) {
+			gone = append(gone, member)
+		}
+		return true
+	})
+	for _, member := range gone {
+		s.leave(ch, member)
+	}
+
+	_, symbols := s.ISupport.Prefix()
+	for _, m := range ch.names {
+		s.join(ch.Name, m.Nick, sortPrefix(m.Prefix, symbols))
+		if m.User != "" {
+			u, _ := s.users.Get(m.Nick)
+			u.user, u.host = m.User, m.Host
+		}
+	}
+	ch.names = nil
+}
+
+// part removes nick from channel name, all channel if nick is me.
+func (s *State) part(name, nick string) {
+	ch, ok := s.channels.Get(name)
+	if !ok {
+		return
+	}
+	if s.isMe(nick) {
+		var members []string
+		ch.members.Range(func(member, _ string) bool {
+			members = append(members, member)
+			return true
+		})
+		for _, member := range members {
+			s.leave(ch, member)
+		}
+		s.channels.Delete(name)
+		return
+	}
+	s.leave(ch, nick)
+}
+
+func (s *State) leave(ch *channel, nick string) {
+	ch.members.Delete(nick)
+	if u, ok := s.users.Get(nick); ok {
+		u.channels.Delete(ch.Name)
+		if u.channels.Len() == 0 {
+			s.users.Delete(nick)
+		}
+	}
+}
+
+func (s *State) rename(old, nick string) {
+	if old == "" || nick == "" {
+		return
+	}
+	if s.isMe(old) {
+		s.me = nick
+	}
+	u, ok := s.users.Get(old)
+	if !ok {
+		return
+	}
+	s.users.Rename(old, nick)
+	u.nick = nick
+	u.channels.Range(func(name string) bool {
+		if ch, ok := s.channels.Get(name); ok {
+			ch.members.Rename(old, nick)
+		}
+		return true
+	})
+}
+
+func (s *State) mode(ch *channel, changes []ModeChange) {
+	modes, symbols := s.ISupport.Prefix()
+	for _, c := range changes {
+		if n := strings.IndexByte(modes, c.Mode); n >= 0 {
+			prefix, ok := ch.members.Get(c.Arg)
+			if !ok {
+				continue
+			}
+			sym := symbols[n]
+			prefix = strings.Replace(prefix, string(sym), "", -1)
+			if c.Add {
+				prefix = sortPrefix(prefix+string(sym), symbols)
+			}
+			ch.members.Set(c.Arg, prefix)
+			continue
+		}
+		if isListMode(s.ISupport, c.Mode) {
+			continue
+		}
+		if c.Add {
+			ch.Modes[c.Mode] = c.Arg
+		} else {
+			delete(ch.Modes, c.Mode)
+		}
+	}
+}
+
+// sortPrefix orders prefix by the order of symbols.
+func sortPrefix(prefix, symbols string) string {
+	if len(prefix) < 2 {
+		return prefix
+	}
+	b := []byte(prefix)
+	sort.Slice(b, func(i, j int) bool {
+		return strings.IndexByte(symbols, b[i]) < strings.IndexByte(symbols, b[j])
+	})
+	return string(b)
+}
+
+// splitMask splits nick!user@host.
+func splitMask(mask string) (nick, user, host string) {
+	nick = mask
+	if n := strings.IndexByte(mask, userSymbol); n >= 0 {
+		nick, user = mask[:n], mask[n+1:]
+	}
+	if n := strings.IndexByte(user, hostSymbol); n >= 0 {
+		user, host = user[:n], user[n+1:]
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package irc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func feedState(t *testing.T, s *State, lines ...string) {
+	for _, l := range lines {
+		msg, err := NewMsg(s2b(l))
+		if err != nil {
+			t.Fatal(l, err)
+		}
+		s.Update(msg)
+	}
+}
+
+func TestState(t *testing.T) {
+	s := NewState()
+	clock := newFakeClock()
+	s.Clock = clock
+	feedState(t, s,
+		":srv 001 me :Welcome",
+		":srv 005 me PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst :are supported",
+		":me!u@h JOIN #go",
+		":srv 332 me #go :Go is fun",
+		":srv 333 me #go alice!a@h 1500000000",
+		":srv 353 me = #go :me ~@alice +bob!b@bobhost",
+		":srv 366 me #go :End of /NAMES list.",
+		":srv 324 me #go +ntk secret",
+		":carol!c@carolhost JOIN :#go",
+	)
+
+	ch, ok := s.Channel("#go")
+	if !ok {
+		t.Fatal("not joined")
+	}
+	if ch.Topic != "Go is fun" || ch.TopicBy != "alice!a@h" || ch.TopicTime.Unix() != 1500000000 {
+		t.Error(ch.Topic, ch.TopicBy, ch.TopicTime)
+	}
+	if len(ch.Members) != 4 || ch.Members["alice"] != "~@" || ch.Members["bob"] != "+" || ch.Members["carol"] != "" {
+		t.Error(ch.Members)
+	}
+	if ch.Modes['k'] != "secret" || len(ch.Modes) != 3 {
+		t.Error(ch.Modes)
+	}
+	if u, ok := s.User("bob"); !ok || u.Host != "bobhost" || len(u.Channels) != 1 {
+		t.Error(u, ok)
+	}
+
+	feedState(t, s,
+		":alice!a@h MODE #go -q+o-k+b alice bob * *!*@spam",
+		":alice!a@h TOPIC #go :new topic",
+		":bob!b@bobhost NICK robert",
+		":carol!c@carolhost PART #go :bye",
+	)
+	ch, _ = s.Channel("#go")
+	if ch.Members["alice"] != "@" || ch.Members["robert"] != "@+" {
+		t.Error(ch.Members)
+	}
+	if _, ok := ch.Members["carol"]; ok {
+		t.Error("carol not parted")
+	}
+	if _, ok := ch.Modes['k']; ok {
+		t.Error(ch.Modes)
+	}
+	if ch.Topic != "new topic" || ch.TopicBy != "alice" || !ch.TopicTime.Equal(clock.Now()) {
+		t.Error(ch.Topic, ch.TopicBy)
+	}
+	if _, ok := s.User("carol"); ok {
+		t.Error("carol still known")
+	}
+	if s.Prefix("#go", "robert") != "@+" {
+		t.Error(s.Prefix("#go", "robert"))
+	}
+
+	feedState(t, s,
+		":alice!a@h KICK #go robert :out",
+		":alice!a@h QUIT :gone",
+	)
+	ch, _ = s.Channel("#go")
+	if len(ch.Members) != 1 {
+		t.Error(ch.Members)
+	}
+
+	feedState(t, s, ":me!u@h NICK me2", ":me2!u@h PART #go")
+	if s.Me() != "me2" || len(s.Channels()) != 0 {
+		t.Error(s.Me(), s.Channels())
+	}
+	if _, ok := s.User("me2"); ok {
+		t.Error("users not cleared")
+	}
+}
+
+func TestStateNames(t *testing.T) {
+	s := NewState()
+	feedState(t, s,
+		":srv 001 me :Welcome",
+		":me!u@h JOIN #go",
+		":srv 353 me = #go :me @alice",
+		":srv 353 me = #go :+bob carol",
+		":srv 366 me #go :End of /NAMES list.",
+		":alice!a@h JOIN #go",
+	)
+	ch, _ := s.Channel("#go")
+	if len(ch.Members) != 4 || ch.Members["alice"] != "@" || ch.Members["bob"] != "+" {
+		t.Error(ch.Members)
+	}
+
+	// a later NAMES replaces members once complete
+	feedState(t, s,
+		":srv 353 me = #go :me @alice",
+		":srv 353 me = #go :+dave",
+	)
+	if ch, _ = s.Channel("#go"); len(ch.Members) != 4 {
+		t.Error("swapped before end", ch.Members)
+	}
+	feedState(t, s, ":srv 366 me #go :End of /NAMES list.")
+	ch, _ = s.Channel("#go")
+	if len(ch.Members) != 3 || ch.Members["dave"] != "+" || ch.Members["alice"] != "@" {
+		t.Error(ch.Members)
+	}
+	if _, ok := s.User("carol"); ok {
+		t.Error("carol still known")
+	}
+}
+
+func TestStateConcurrent(t *testing.T) {
+	s := NewState()
+	feedState(t, s, ":srv 001 me :Welcome", ":me!u@h JOIN #go")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			ch, _ := s.Channel("#go")
+			_ = len(ch.Members)
+			s.User("me")
+			time.Sleep(0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		feedState(t, s, ":a!b@c JOIN #go", ":a!b@c PART #go")
+	}
+	wg.Wait()
+}
+
+func TestStateCaseMapping(t *testing.T) {
+	s := NewState()
+	feedState(t, s,
+		":srv 001 Me :Welcome",
+		":ME!u@h JOIN #Foo[1]",
+		":srv 353 me = #foo{1} :Me @Alice[m]",
+		":srv 366 me #foo{1} :End of /NAMES list.",
+		":alice{M} MODE #FOO[1] +v ALICE[M]",
+		":ALICE[m] NICK Alice|",
+	)
+	if names := s.Channels(); len(names) != 1 || names[0] != "#Foo[1]" {
+		t.Fatal(names)
+	}
+	if p := s.Prefix("#foo{1}", "alice\\"); p != "@+" {
+		t.Errorf("prefix %q", p)
+	}
+	u, ok := s.User("ALICE|")
+	if !ok || u.Nick != "Alice|" || len(u.Channels) != 1 {
+		t.Error(u, ok)
+	}
+
+	feedState(t, s,
+		":srv 005 me CASEMAPPING=ascii :are supported",
+		":srv 353 me = #Foo[1] :Me bob",
+		":srv 366 me #Foo[1] :End of /NAMES list.",
+	)
+	if _, ok := s.Channel("#foo{1}"); ok {
+		t.Error("ascii folded []")
+	}
+	if p := s.Prefix("#FOO[1]", "BOB"); p != "" {
+		t.Errorf("prefix %q", p)
+	}
+	if _, ok := s.User("Bob"); !ok {
+		t.Error("bob not found")
+	}
+
+	feedState(t, s, ":me PART #foo[1]")
+	if names := s.Channels(); len(names) != 0 {
+		t.Error(names)
+	}
+}
@@ -0,0 +1,200 @@
+package irc
+
+import "bytes"
+
+// IRCv3 message-tags, see http://ircv3.net/specs/core/message-tags-3.2.html
+const (
+	tagSep      byte = 0x3b // ;
+	tagValueSep byte = 0x3d // =
+	tagEscape   byte = 0x5c // \
+)
+
+// Tags returns the raw (escaped) tags section without the leading '@'.
+func (m *Msg) Tags() []byte {
+	return m.tags
+}
+
+// Tag returns the raw (escaped) value of key and whether key is present.
+// Use UnescapeTag to get the real value.
+func (m *Msg) Tag(key []byte) (value []byte, ok bool) {
+	m.RangeTags(func(k, v []byte) bool {
+		if bytes.Equal(k, key) {
+			value, ok = v, true
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// RangeTags calls f for each tag in order with the raw (escaped) value,
+// it stops if f returns false.
+func (m *Msg) RangeTags(f func(key, value []byte) bool) {
+	b := m.tags
+	for len(b) != 0 {
+		n := bytes.IndexByte(b, tagSep)
+		var t []byte
+		if n < 0 {
+			t, b = b, nil
+		} else {
+			t, b = b[:n], b[n+1:]
+		}
+		if len(t) == 0 {
+			continue
+		}
+
+		var k, v []byte
+		if n = bytes.IndexByte(t, tagValueSep); n < 0 {
+			k = t
+		} else {
+			k, v = t[:n], t[n+1:]
+		}
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// SetTag sets key to the (unescaped) value, replacing any tag with the same
+// key. An empty value produces a tag without value.
+func (m *Msg) SetTag(key, value []byte) {
+	if _, ok := m.Tag(key); ok {
+		// rebuild without the old key
+		buf := make([]byte, 0, len(m.tags)+len(key)+len(value)+2)
+		m.RangeTags(func(k, v []byte) bool {
+			if !bytes.Equal(k, key) {
+				buf = appendTag(buf, k)
+				if len(v) != 0 {
+					buf = append(buf, tagValueSep)
+					buf = append(buf, v...)
+				}
+			}
+			return true
+		})
+		m.tagBuf = buf
+	} else if len(m.tagBuf) == 0 {
+		// tags may point into Data, never append to them
+		m.tagBuf = append(m.tagBuf[:0], m.tags...)
+	}
+
+	m.tagBuf = appendTag(m.tagBuf, key)
+	if len(value) != 0 {
+		m.tagBuf = append(m.tagBuf, tagValueSep)
+		m.tagBuf = EscapeTag(m.tagBuf, value)
+	}
+	m.tags = m.tagBuf
+}
+
+func appendTag(dst, key []byte) []byte {
+	if len(dst) != 0 {
+		dst = append(dst, tagSep)
+	}
+	return append(dst, key...)
+}
+
+// EscapeTag appends the escaped form of value to dst and returns the
+// extended buffer.
+func EscapeTag(dst, value []byte) []byte {
+	for _, c := range value {
+		switch c {
+		case ';':
+			dst = append(dst, tagEscape, ':')
+		case ' ':
+			dst = append(dst, tagEscape, 's')
+		case '\\':
+			dst = append(dst, tagEscape, '\\')
+		case '\r':
+			dst = append(dst, tagEscape, 'r')
+		case '\n':
+			dst = append(dst, tagEscape, 'n')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// UnescapeTag appends the unescaped form of value to dst and returns the
+// extended buffer.
+func UnescapeTag(dst, value []byte) []byte {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != tagEscape {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		if i == len(value) {
+			// trailing backslash is dropped
+			break
+		}
+		switch c = value[i]; c {
+		case ':':
+			dst = append(dst, ';')
+		case 's':
+			dst = append(dst, ' ')
+		case 'r':
+			dst = append(dst, '\r')
+		case 'n':
+			dst = append(dst, '\n')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+var (
+	msgidTag      = []byte("msgid")
+	accountTag    = []byte("account")
+	labelTag      = []byte("label")
+	replyTag      = []byte("+reply")
+	draftReplyTag = []byte("+draft/reply")
+)
+
+// tagValue returns the unescaped value of key, nil if it is missing. A
+// value without escapes is not copied and is valid until msg is reused.
+func (m *Msg) tagValue(key []byte) []byte {
+	v, ok := m.Tag(key)
+	switch {
+	case !ok:
+		return nil
+	case v == nil:
+		return []byte{}
+	case bytes.IndexByte(v, tagEscape) < 0:
+		return v[:len(v):len(v)]
+	}
+	return UnescapeTag(make([]byte, 0, len(v)), v)
+}
+
+// MsgID returns the msgid tag, see https://ircv3.net/specs/extensions/message-ids
+// Like Tag, values of MsgID, Account, Label and ReplyTo may point into msg.
+func (m *Msg) MsgID() []byte {
+	return m.tagValue(msgidTag)
+}
+
+// Account returns the account tag of the sender, see
+// https://ircv3.net/specs/extensions/account-tag
+func (m *Msg) Account() []byte {
+	return m.tagValue(accountTag)
+}
+
+// Label returns the label tag of labeled-response.
+func (m *Msg) Label() []byte {
+	return m.tagValue(labelTag)
+}
+
+// ReplyTo returns the msgid the msg replies to by the +reply client tag or
+// its draft +draft/reply.
+func (m *Msg) ReplyTo() []byte {
+	if v := m.tagValue(replyTag); v != nil {
+		return v
+	}
+	return m.tagValue(draftReplyTag)
+}
+
+// SetReplyTo sets the +draft/reply client tag to msgid, servers accepting
+// only the ratified name need SetTag with +reply.
+func (m *Msg) SetReplyTo(msgid []byte) {
+	m.SetTag(draftReplyTag, msgid)
+}
@@ -0,0 +1,197 @@
+package irc
+
+import (
+	"bytes"
+	"testing"
+)
+
+var tagTests = []struct {
+	rawMsg string
+	tags   map[string]string
+	cmd    string
+	name   string
+	params []string
+}{
+	{
+		"@time=2011-10-19T16:40:51.620Z;msgid=abc :nick!u@h PRIVMSG #chan :hi there",
+		map[string]string{"time": "2011-10-19T16:40:51.620Z", "msgid": "abc"},
+		"PRIVMSG", "nick", []string{"#chan"},
+	},
+	{
+		"@aaa=bbb;ccc;example.com/ddd=eee :nick!ident@host.com PRIVMSG me :Hello",
+		map[string]string{"aaa": "bbb", "ccc": "", "example.com/ddd": "eee"},
+		"PRIVMSG", "nick", []string{"me"},
+	},
+	{
+		`@+draft/reply=a\sb\:c\\d PING`,
+		map[string]string{"+draft/reply": `a\sb\:c\\d`},
+		"PING", "", nil,
+	},
+	{
+		"@account=foo;= CAP * LS",
+		map[string]string{"account": "foo"},
+		"CAP", "", []string{"*", "LS"},
+	},
+}
+
+func TestMsgTags(t *testing.T) {
+	for _, z := range tagTests {
+		m, err := NewMsg(s2b(z.rawMsg))
+		if err != nil {
+			t.Fatal(z.rawMsg, err)
+		}
+		if string(m.Cmd()) != z.cmd || string(m.Name()) != z.name {
+			t.Errorf("failed:%s\nparsed:%s", z.rawMsg, m.String())
+		}
+		if len(m.Params()) != len(z.params) {
+			t.Errorf("failed:%s\nparsed:%s", z.rawMsg, m.String())
+		}
+		for i, p := range z.params {
+			if string(m.Params()[i]) != p {
+				t.Errorf("failed:%s\nparsed:%s", z.rawMsg, m.String())
+			}
+		}
+		for k, v := range z.tags {
+			val, ok := m.Tag(s2b(k))
+			if !ok || string(val) != v {
+				t.Errorf("%s: tag %s=%q, got %q %v", z.rawMsg, k, v, val, ok)
+			}
+		}
+		if _, ok := m.Tag(s2b("missing")); ok {
+			t.Error(z.rawMsg, "has missing tag")
+		}
+	}
+}
+
+func TestInvalidTags(t *testing.T) {
+	invalid := []string{
+		"@ PRIVMSG test :empty tags",
+		"@a=b",
+	}
+	for _, s := range invalid {
+		m, err := NewMsg(s2b(s))
+		if err == nil || m.Cmd() != nil {
+			t.Error(s, "is valid")
+		}
+	}
+}
+
+func TestRangeTags(t *testing.T) {
+	m, _ := NewMsg(s2b("@a=1;b;c=3 PING"))
+	var keys []string
+	m.RangeTags(func(k, v []byte) bool {
+		keys = append(keys, string(k))
+		return string(k) != "b"
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Error(keys)
+	}
+}
+
+func TestEscapeTag(t *testing.T) {
+	raw := "a; b\\c\r\nd"
+	escaped := `a\:\sb\\c\r\nd`
+	if got := string(EscapeTag(nil, s2b(raw))); got != escaped {
+		t.Errorf("escape %q got %q", raw, got)
+	}
+	if got := string(UnescapeTag(nil, s2b(escaped))); got != raw {
+		t.Errorf("unescape %q got %q", escaped, got)
+	}
+	if got := string(UnescapeTag(nil, s2b(`\b\`))); got != "b" {
+		t.Errorf("unescape unknown got %q", got)
+	}
+}
+
+func TestSetTag(t *testing.T) {
+	src := s2b("@a=1;b=2 :nick PRIVMSG #chan :hi")
+	data := append([]byte{}, src...)
+	m, _ := NewMsg(data)
+	m.SetTag(s2b("c"), s2b("x y"))
+	m.SetTag(s2b("a"), nil)
+	if !bytes.Equal(data, src) {
+		t.Error("SetTag modified Data", string(data))
+	}
+	if string(m.Tags()) != `b=2;c=x\sy;a` {
+		t.Error(string(m.Tags()))
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	enc := NewEncoder(buf)
+	m.ParseAll()
+	if _, err := enc.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "@b=2;c=x\\sy;a :nick PRIVMSG #chan :hi\r\n" {
+		t.Errorf("%q", buf.String())
+	}
+
+	m.Reset()
+	if m.Tags() != nil {
+		t.Error(m.Tags())
+	}
+}
+
+func TestTagAlloc(t *testing.T) {
+	src := s2b("@time=2011-10-19T16:40:51.620Z;msgid=abc :nick!u@h PRIVMSG #chan :hi there")
+	m := new(Msg)
+	key := s2b("msgid")
+	dst := make([]byte, 0, 64)
+	n := testing.AllocsPerRun(100, func() {
+		m.Reset()
+		m.Data = src
+		m.ParseAll()
+		v, _ := m.Tag(key)
+		dst = UnescapeTag(dst[:0], v)
+	})
+	if n != 0 {
+		t.Error("allocs", n)
+	}
+}
+
+func TestTagAccessorsAlloc(t *testing.T) {
+	m := newTestMsg("@msgid=abc;account=bob;label=7;+reply=xyz :bob!b@h PRIVMSG #go :hi")
+	n := testing.AllocsPerRun(100, func() {
+		m.MsgID()
+		m.Account()
+		m.Label()
+		m.ReplyTo()
+	})
+	if n != 0 {
+		t.Error("allocs", n)
+	}
+}
+
+func BenchmarkParseMessage_tags(b *testing.B) {
+	src := s2b("@time=2011-10-19T16:40:51.620Z;msgid=abc :Namename COMMAND arg6 arg7 :Message message message\r\n")
+	key := s2b("msgid")
+	m := new(Msg)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Data = src
+		m.ParseAll()
+		m.Tag(key)
+		m.Reset()
+	}
+}
+
+func TestTagAccessors(t *testing.T) {
+	m := newTestMsg(`@msgid=a\sb;account=bob;label=7;+draft/reply=x :bob!b@h PRIVMSG #go :hi`)
+	if string(m.MsgID()) != "a b" || string(m.Account()) != "bob" ||
+		string(m.Label()) != "7" || string(m.ReplyTo()) != "x" {
+		t.Errorf("%q %q %q %q", m.MsgID(), m.Account(), m.Label(), m.ReplyTo())
+	}
+
+	m = newTestMsg("@+reply=y;+draft/reply=x PRIVMSG #go :hi")
+	if string(m.ReplyTo()) != "y" {
+		t.Error(string(m.ReplyTo()))
+	}
+
+	m = newTestMsg("PRIVMSG #go :hi")
+	if m.MsgID() != nil || m.Account() != nil || m.Label() != nil || m.ReplyTo() != nil {
+		t.Error("tags of untagged msg")
+	}
+	m.SetReplyTo([]byte("a;b"))
+	if v, _ := m.Tag([]byte("+draft/reply")); string(v) != `a\:b` || string(m.ReplyTo()) != "a;b" {
+		t.Error(string(v))
+	}
+}
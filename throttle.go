@@ -0,0 +1,115 @@
+package irc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle is a rate-limited Writer to avoid "Excess Flood".
+//
+// Every msg costs Cost(msg) on a virtual timer which never falls behind the
+// clock, a msg waits until the timer is at most Window ahead of now.
+// A constant cost of interval and a window of (burst-1)*interval is the
+// token bucket of NewThrottle, a cost based on msg size is the penalty
+// scheme of ircd used by NewPenaltyThrottle.
+//
+// Msgs matching Priority are written immediately but still cost.
+type Throttle struct {
+	W        Writer
+	Cost     func(msg *Msg) time.Duration
+	Window   time.Duration
+	Priority func(msg *Msg) bool
+	Clock    Clock
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewThrottle returns a token bucket Throttle which sends a msg every
+// interval with a burst of burst msgs.
+func NewThrottle(w Writer, interval time.Duration, burst int) *Throttle {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Throttle{
+		W:        w,
+		Cost:     func(*Msg) time.Duration { return interval },
+		Window:   time.Duration(burst-1) * interval,
+		Priority: IsPong,
+		Clock:    SystemClock,
+	}
+}
+
+// NewPenaltyThrottle returns a Throttle that charges 2 seconds plus one
+// second per 120 bytes of a msg, with a 10 seconds window.
+func NewPenaltyThrottle(w Writer) *Throttle {
+	return &Throttle{
+		W:        w,
+		Cost:     Penalty,
+		Window:   10 * time.Second,
+		Priority: IsPong,
+		Clock:    SystemClock,
+	}
+}
+
+// Penalty returns the ircd style penalty of msg.
+func Penalty(msg *Msg) time.Duration {
+	n := len(msg.Cmd()) + len(msg.Trailing())
+	for _, p := range msg.Params() {
+		n += len(p) + 1
+	}
+	return 2*time.Second + time.Duration(n/120)*time.Second
+}
+
+// IsPong reports whether msg is a PONG.
+func IsPong(msg *Msg) bool {
+	return string(msg.Cmd()) == PONG
+}
+
+// Encode msg when the rate allows.
+func (t *Throttle) Encode(msg *Msg) (n int, err error) {
+	return t.EncodeContext(context.Background(), msg)
+}
+
+// EncodeContext encodes msg when the rate allows, it returns ctx.Err() if
+// ctx is done before that.
+func (t *Throttle) EncodeContext(ctx context.Context, msg *Msg) (n int, err error) {
+	cost := t.Cost(msg)
+	priority := t.Priority != nil && t.Priority(msg)
+
+	t.mu.Lock()
+	now := t.Clock.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	at := t.next.Add(-t.Window)
+	t.next = t.next.Add(cost)
+	end := t.next
+	t.mu.Unlock()
+
+	if !priority && at.After(now) {
+		select {
+		case <-t.Clock.After(at.Sub(now)):
+		case <-ctx.Done():
+			t.mu.Lock()
+			if t.next.Equal(end) {
+				// give back the slot if no one queued after us
+				t.next = t.next.Add(-cost)
+			}
+			t.mu.Unlock()
+			return 0, ctx.Err()
+		}
+	}
+	return t.W.Encode(msg)
+}
+
+// Delay returns how long a non-priority msg would wait now.
+func (t *Throttle) Delay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d := t.next.Add(-t.Window).Sub(t.Clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
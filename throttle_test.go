@@ -0,0 +1,188 @@
+package irc
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves by Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{c.now.Add(d), make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiters
+}
+
+// Wait until n goroutines are waiting on the clock.
+func (c *fakeClock) Wait(n int) {
+	for {
+		c.mu.Lock()
+		l := len(c.waiters)
+		c.mu.Unlock()
+		if l >= n {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := strings.TrimSuffix(b.buf.String(), "\r\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\r\n")
+}
+
+func newTestMsg(raw string) *Msg {
+	msg, _ := NewMsg(s2b(raw))
+	msg.ParseAll()
+	return msg
+}
+
+func TestThrottleBurst(t *testing.T) {
+	buf := new(lockedBuffer)
+	clock := newFakeClock()
+	th := NewThrottle(NewEncoder(buf), 2*time.Second, 5)
+	th.Clock = clock
+
+	for i := 0; i < 5; i++ {
+		th.Encode(newTestMsg("PRIVMSG #chan :burst"))
+	}
+	if n := len(buf.Lines()); n != 5 {
+		t.Fatal(n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		th.Encode(newTestMsg("PRIVMSG #chan :slow"))
+		close(done)
+	}()
+	clock.Wait(1)
+
+	// PONG bypasses the queue
+	th.Encode(newTestMsg("PONG :srv"))
+	if l := buf.Lines(); len(l) != 6 || l[5] != "PONG :srv" {
+		t.Fatal(l)
+	}
+
+	clock.Advance(time.Second)
+	if len(buf.Lines()) != 6 {
+		t.Error("sent too early")
+	}
+	clock.Advance(time.Second)
+	<-done
+	if l := buf.Lines(); len(l) != 7 || l[6] != "PRIVMSG #chan :slow" {
+		t.Error(l)
+	}
+	if d := th.Delay(); d != 4*time.Second {
+		t.Error(d)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	buf := new(lockedBuffer)
+	clock := newFakeClock()
+	th := NewThrottle(NewEncoder(buf), time.Second, 1)
+	th.Clock = clock
+
+	th.Encode(newTestMsg("PRIVMSG #chan :1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := th.EncodeContext(ctx, newTestMsg("PRIVMSG #chan :2"))
+		errc <- err
+	}()
+	clock.Wait(1)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Error(err)
+	}
+	if d := th.Delay(); d != time.Second {
+		t.Error("slot not given back", d)
+	}
+	if len(buf.Lines()) != 1 {
+		t.Error(buf.Lines())
+	}
+}
+
+func TestPenalty(t *testing.T) {
+	if p := Penalty(newTestMsg("PRIVMSG #c :hi")); p != 2*time.Second {
+		t.Error(p)
+	}
+	long := newTestMsg("PRIVMSG #c :" + strings.Repeat("x", 250))
+	if p := Penalty(long); p != 4*time.Second {
+		t.Error(p)
+	}
+
+	buf := new(lockedBuffer)
+	clock := newFakeClock()
+	th := NewPenaltyThrottle(NewEncoder(buf))
+	th.Clock = clock
+	for i := 0; i < 3; i++ {
+		th.Encode(long)
+	}
+	if th.Delay() != 2*time.Second {
+		t.Error(th.Delay())
+	}
+}